@@ -0,0 +1,304 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis"
+	"github.com/blugelabs/bluge/analysis/analyzer"
+	"github.com/blugelabs/bluge/analysis/char"
+	"github.com/blugelabs/bluge/analysis/lang/de"
+	"github.com/blugelabs/bluge/analysis/lang/en"
+	"github.com/blugelabs/bluge/analysis/lang/fr"
+	"github.com/blugelabs/bluge/analysis/token"
+	"github.com/blugelabs/bluge/analysis/tokenizer"
+)
+
+// analyzers are the analyzers text fields may be configured with.  All but
+// standard fold accented characters to ASCII, so "brau" matches "Bräu".
+var analyzers = map[string]*analysis.Analyzer{
+	"standard": analyzer.NewStandardAnalyzer(),
+	"folding": {
+		Tokenizer: tokenizer.NewUnicodeTokenizer(),
+		TokenFilters: []analysis.TokenFilter{
+			token.NewLowerCaseFilter(),
+			foldingFilter{},
+		},
+	},
+	"en": {
+		Tokenizer: tokenizer.NewUnicodeTokenizer(),
+		TokenFilters: []analysis.TokenFilter{
+			foldingFilter{},
+			en.NewPossessiveFilter(),
+			token.NewLowerCaseFilter(),
+			en.StemmerFilter(),
+		},
+	},
+	"de": {
+		Tokenizer: tokenizer.NewUnicodeTokenizer(),
+		TokenFilters: []analysis.TokenFilter{
+			token.NewLowerCaseFilter(),
+			de.NormalizeFilter(),
+			foldingFilter{},
+			de.LightStemmerFilter(),
+		},
+	},
+	"fr": {
+		Tokenizer: tokenizer.NewUnicodeTokenizer(),
+		TokenFilters: []analysis.TokenFilter{
+			fr.ElisionFilter(),
+			token.NewLowerCaseFilter(),
+			fr.LightStemmerFilter(),
+			foldingFilter{},
+		},
+	},
+}
+
+// defaultFieldAnalyzers stem the English text of beers and breweries.  The
+// fields included in _all should be analyzed the same way as _all, which
+// is how queries on _all are analyzed.
+var defaultFieldAnalyzers = map[string]string{
+	"_all":        "en",
+	"name":        "en",
+	"desc":        "en",
+	"style":       "en",
+	"category":    "en",
+	"city":        "en",
+	"state":       "en",
+	"country":     "en",
+	"address":     "en",
+	"beer_styles": "en",
+	"main_style":  "en",
+}
+
+// languageFields are analyzed in the language of the document, when
+// language analysis is enabled
+var languageFields = map[string]bool{
+	"_all": true,
+	"name": true,
+	"desc": true,
+}
+
+// countryLanguages are the languages analyzing the documents of breweries
+// in the countries, and their beers
+var countryLanguages = map[string]string{
+	"Germany":       "de",
+	"Austria":       "de",
+	"Switzerland":   "de",
+	"Liechtenstein": "de",
+	"France":        "fr",
+	"Belgium":       "fr",
+	"Luxembourg":    "fr",
+	"Monaco":        "fr",
+}
+
+func languageForCountry(country string) string {
+	return countryLanguages[country]
+}
+
+// foldingFilter folds the characters of terms to ASCII.  Unlike folding
+// the text before it is tokenized, it leaves the offsets of the terms in
+// the original text, which highlighting relies on.
+type foldingFilter struct{}
+
+var asciiFolding = char.NewASCIIFoldingFilter()
+
+func (foldingFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	for _, tok := range input {
+		tok.Term = asciiFolding.Filter(tok.Term)
+	}
+	return input
+}
+
+// textAnalysis configures how text is analyzed, when indexing and
+// searching
+var textAnalysis = NewTextAnalysis(nil, false)
+
+// TextAnalysis picks the analyzer of each text field, and with languages
+// enabled, the analyzer of name and desc by the language of the document
+type TextAnalysis struct {
+	fields    map[string]string
+	languages bool
+}
+
+// NewTextAnalysis analyzes the fields with the default analyzers, replaced
+// by the provided ones
+func NewTextAnalysis(fields map[string]string, languages bool) *TextAnalysis {
+	rv := &TextAnalysis{
+		fields:    make(map[string]string, len(defaultFieldAnalyzers)+len(fields)),
+		languages: languages,
+	}
+	for field, name := range defaultFieldAnalyzers {
+		rv.fields[field] = name
+	}
+	for field, name := range fields {
+		rv.fields[field] = name
+	}
+	return rv
+}
+
+// ParseFieldAnalyzers parses field=analyzer pairs separated by commas
+func ParseFieldAnalyzers(in string) (map[string]string, error) {
+	rv := make(map[string]string)
+	for _, pair := range strings.Split(in, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		equals := strings.Index(pair, "=")
+		if equals < 1 {
+			return nil, fmt.Errorf("field analyzer must be field=analyzer, got '%s'", pair)
+		}
+		field, name := pair[:equals], pair[equals+1:]
+		if _, ok := analyzers[name]; !ok {
+			names := make([]string, 0, len(analyzers))
+			for name := range analyzers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown analyzer '%s' for field '%s', expected one of: %s", name, field,
+				strings.Join(names, ", "))
+		}
+		rv[field] = name
+	}
+	return rv, nil
+}
+
+// analyzedField returns the field searched, queries without a field search
+// _all
+func analyzedField(field string) string {
+	if field == "" {
+		return "_all"
+	}
+	return field
+}
+
+// Analyzer returns the analyzer of the field for documents in the language,
+// nil for fields analyzed by the default analyzer
+func (a *TextAnalysis) Analyzer(field, language string) *analysis.Analyzer {
+	if a.languages && languageFields[field] && language != "" {
+		return analyzers[language]
+	}
+	return analyzers[a.fields[field]]
+}
+
+// Languages returns the languages documents may have analyzed the field in
+func (a *TextAnalysis) Languages(field string) []string {
+	if !a.languages || !languageFields[field] {
+		return nil
+	}
+	return []string{"de", "fr"}
+}
+
+// TextField builds the text field, analyzed for the language of the
+// document
+func (a *TextAnalysis) TextField(field, value, language string) *bluge.TermField {
+	rv := bluge.NewTextField(field, value)
+	if fieldAnalyzer := a.Analyzer(field, language); fieldAnalyzer != nil {
+		rv.WithAnalyzer(fieldAnalyzer)
+	}
+	return rv
+}
+
+// beerLanguage returns the language of the country of the beer's brewery,
+// when language analysis is enabled.  It opens a reader for the one beer,
+// changing many beers should share a reader with breweryLanguage.
+func beerLanguage(breweryIndexWriter *bluge.Writer, breweryID string) (string, error) {
+	if !textAnalysis.languages {
+		return "", nil
+	}
+	breweryReader, err := breweryIndexWriter.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = breweryReader.Close()
+	}()
+	return breweryLanguage(breweryReader, breweryID)
+}
+
+// breweryLanguage returns the language of the country of the brewery, when
+// language analysis is enabled
+func breweryLanguage(breweryReader *bluge.Reader, breweryID string) (string, error) {
+	if !textAnalysis.languages {
+		return "", nil
+	}
+	breweries, err := loadBreweries(breweryReader, []string{breweryID})
+	if err != nil {
+		return "", err
+	}
+	if brewery, ok := breweries[breweryID]; ok {
+		return languageForCountry(brewery.Country), nil
+	}
+	return "", nil
+}
+
+// analyzeText returns the terms of the text, separated by spaces
+func analyzeText(textAnalyzer *analysis.Analyzer, text string) string {
+	tokens := textAnalyzer.Analyze([]byte(text))
+	terms := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		terms = append(terms, string(tok.Term))
+	}
+	return strings.Join(terms, " ")
+}
+
+// AnalyzeQueryString replaces the terms and phrases of the query string by
+// their terms as analyzed for the field searched, since the query string
+// parser only knows the default analyzer.  The terms it leaves alone
+// remain the same when analyzed again by the default analyzer.  Fields
+// analyzed by language have the terms of every language added, the same
+// way as synonyms.
+func (a *TextAnalysis) AnalyzeQueryString(q string) []string {
+	raws := splitQueryString(q)
+	clauses := make([]*queryClause, len(raws))
+	var analyzed bool
+	var matches []*synonymMatch
+	for i, raw := range raws {
+		clause := parseQueryClause(raw, func(field string) bool {
+			return a.fields[analyzedField(field)] != ""
+		})
+		clauses[i] = clause
+		text := clause.word + clause.phrase
+		if text == "" {
+			continue
+		}
+		field := analyzedField(clause.field)
+		terms := analyzeText(a.Analyzer(field, ""), text)
+		if terms == "" {
+			continue
+		}
+		raws[i] = clause.format(clause.prefix, terms)
+		analyzed = true
+		var languageTerms []string
+		for _, language := range a.Languages(field) {
+			alternative := analyzeText(a.Analyzer(field, language), text)
+			if alternative != "" && alternative != terms && !containsString(languageTerms, alternative) {
+				languageTerms = append(languageTerms, alternative)
+			}
+		}
+		if len(languageTerms) > 0 {
+			matches = append(matches, &synonymMatch{start: i, end: i + 1, synonyms: languageTerms})
+		}
+	}
+	if !analyzed {
+		return []string{q}
+	}
+	return expandClauses(raws, clauses, matches)
+}
@@ -0,0 +1,201 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestParseFieldAnalyzers(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		expect  map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			in:     "",
+			expect: map[string]string{},
+		},
+		{
+			name:   "fields",
+			in:     "name=folding, desc=standard",
+			expect: map[string]string{"name": "folding", "desc": "standard"},
+		},
+		{
+			name:    "unknown analyzer",
+			in:      "desc=klingon",
+			wantErr: true,
+		},
+		{
+			name:    "missing field",
+			in:      "=en",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseFieldAnalyzers(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected analyzers: %v, got: %v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestAnalyzers(t *testing.T) {
+	tests := []struct {
+		analyzer string
+		in       string
+		expect   string
+	}{
+		{
+			analyzer: "standard",
+			in:       "Früh Kölsch",
+			expect:   "früh kölsch",
+		},
+		{
+			analyzer: "folding",
+			in:       "Früh Kölsch",
+			expect:   "fruh kolsch",
+		},
+		{
+			analyzer: "en",
+			in:       "Adler Bräu's Hops brewing",
+			expect:   "adler brau hop brew",
+		},
+		{
+			analyzer: "de",
+			in:       "Brauereien Früh Weißbier",
+			expect:   "brauerei fruh weissbi",
+		},
+		{
+			analyzer: "fr",
+			in:       "l'Abbaye des Bières",
+			expect:   "abay des bier",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.analyzer, func(t *testing.T) {
+			got := analyzeText(analyzers[test.analyzer], test.in)
+			if got != test.expect {
+				t.Errorf("expected terms '%s', got '%s'", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestAnalyzeQueryString(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		languages bool
+		expect    []string
+	}{
+		{
+			name:   "terms and phrases",
+			in:     `Hops +name:Bräu -"pale ales"`,
+			expect: []string{`hop +name:brau -"pale ale"`},
+		},
+		{
+			name:   "fields not analyzed",
+			in:     "brewery_id:Hops abv:>5 hop*",
+			expect: []string{"brewery_id:Hops abv:>5 hop*"},
+		},
+		{
+			name:      "languages",
+			in:        "Hops +desc:Brauereien",
+			languages: true,
+			expect: []string{
+				"hop +desc:brauereien hops",
+				"hop +desc:brauerei hops",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := NewTextAnalysis(nil, test.languages).AnalyzeQueryString(test.in)
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected query strings: %q, got: %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestSearchAnalyzed(t *testing.T) {
+	beerReader := openTestIndex(t, typeBeer, func(filename string) bool {
+		return strings.HasPrefix(filename, "appleton_brewing-adler_brau")
+	})
+	defer func() {
+		_ = beerReader.Close()
+	}()
+
+	count := func(q string) uint64 {
+		query, err := parseQueryString(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dmi, err := beerReader.Search(context.Background(), bluge.NewTopNSearch(0, query).WithStandardAggregations())
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dmi.Aggregations().Count()
+	}
+
+	tests := []struct {
+		query string
+		same  string
+	}{
+		{query: "name:brau", same: "name:Bräu"},
+		{query: "lagers", same: "lager"},
+	}
+	for _, test := range tests {
+		got, expect := count(test.query), count(test.same)
+		if expect == 0 {
+			t.Errorf("expected '%s' to match", test.same)
+		}
+		if got != expect {
+			t.Errorf("expected '%s' to match %d beers like '%s', got %d", test.query, expect, test.same, got)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const eventSearch = "search"
+const eventClick = "click"
+
+// AnalyticsEvent is a search or a click on one of its hits, as recorded in
+// the analytics store
+type AnalyticsEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Query    string    `json:"query"`
+	Filters  []*Filter `json:"filters,omitempty"`
+	Total    uint64    `json:"total,omitempty"`
+	DocID    string    `json:"doc_id,omitempty"`
+	Position int       `json:"position,omitempty"`
+}
+
+// AnalyticsStore appends searches and clicks to a file of JSON lines, and
+// keeps those within the retention period in memory to report on
+type AnalyticsStore struct {
+	path      string
+	retention time.Duration
+
+	m      sync.Mutex
+	w      io.WriteCloser
+	events []*AnalyticsEvent
+}
+
+// OpenAnalyticsStore loads the events of the file within the retention
+// period, dropping the older ones from the file, and appends new events to it
+func OpenAnalyticsStore(path string, retention time.Duration) (*AnalyticsStore, error) {
+	rv := &AnalyticsStore{
+		path:      path,
+		retention: retention,
+	}
+	expired, skipped, err := rv.load()
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		appLog.Warn("skipped unparsable analytics events", LogFields{"path": path, "lines": skipped})
+	}
+	// the unparsable lines are dropped too, a partial last line would
+	// otherwise run into the next event appended
+	if expired > 0 || skipped > 0 {
+		err = rv.rewrite()
+		if err != nil {
+			return nil, err
+		}
+	}
+	rv.w, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening analytics store '%s': %v", path, err)
+	}
+	return rv, nil
+}
+
+// load reads the events within the retention period, returning how many
+// older events the file has, and how many lines could not be parsed, like
+// a partial line left by a crash while appending
+func (s *AnalyticsStore) load() (expired, skipped int, err error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("error opening analytics store '%s': %v", s.path, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	cutoff := time.Now().Add(-s.retention)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		event := &AnalyticsEvent{}
+		err = json.Unmarshal(scanner.Bytes(), event)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if event.Time.After(cutoff) {
+			s.events = append(s.events, event)
+		} else {
+			expired++
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading analytics store '%s': %v", s.path, err)
+	}
+	return expired, skipped, nil
+}
+
+// rewrite replaces the file with the events kept in memory, so that the
+// file does not grow past the retention period
+func (s *AnalyticsStore) rewrite() error {
+	tmpPath := s.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error rewriting analytics store '%s': %v", s.path, err)
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, event := range s.events {
+		err = encoder.Encode(event)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("error rewriting analytics store '%s': %v", s.path, err)
+	}
+	return nil
+}
+
+func (s *AnalyticsStore) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.w.Close()
+}
+
+// normalizeQuery lowercases the query and collapses its whitespace, so
+// that the same query typed differently is counted together
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// RecordSearch records the search, only the first page of results is
+// recorded so that paging does not count as searching again
+func (s *AnalyticsStore) RecordSearch(r *SearchRequest, total uint64) error {
+	if r.Page > 1 || r.Cursor != "" {
+		return nil
+	}
+	return s.record(&AnalyticsEvent{
+		Type:    eventSearch,
+		Time:    time.Now().UTC(),
+		Query:   normalizeQuery(r.Query),
+		Filters: r.Filters,
+		Total:   total,
+	})
+}
+
+// RecordClick records a click on the hit with the document ID, at its
+// position in the results of the query, starting at 1
+func (s *AnalyticsStore) RecordClick(query, docID string, position int) error {
+	return s.record(&AnalyticsEvent{
+		Type:     eventClick,
+		Time:     time.Now().UTC(),
+		Query:    normalizeQuery(query),
+		DocID:    docID,
+		Position: position,
+	})
+}
+
+func (s *AnalyticsStore) record(event *AnalyticsEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, err = s.w.Write(line)
+	if err != nil {
+		return fmt.Errorf("error appending to analytics store '%s': %v", s.path, err)
+	}
+	s.events = append(s.events, event)
+	if s.expire(event.Time) {
+		return s.compact()
+	}
+	return nil
+}
+
+// expire forgets the events older than the retention period once they are
+// at least half of the events, returning whether it did.  The events are in
+// the order they were recorded.
+func (s *AnalyticsStore) expire(now time.Time) bool {
+	cutoff := now.Add(-s.retention)
+	i := sort.Search(len(s.events), func(i int) bool {
+		return s.events[i].Time.After(cutoff)
+	})
+	if i > 0 && i >= len(s.events)/2 {
+		s.events = append([]*AnalyticsEvent(nil), s.events[i:]...)
+		return true
+	}
+	return false
+}
+
+// compact rewrites the file with the events kept in memory, and appends to
+// the new file.  The file then holds at most twice the events within the
+// retention period.
+func (s *AnalyticsStore) compact() error {
+	err := s.rewrite()
+	if err != nil {
+		// the events are still appended to the old file
+		return err
+	}
+	w, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening analytics store '%s': %v", s.path, err)
+	}
+	_ = s.w.Close()
+	s.w = w
+	return nil
+}
+
+type QueryStats struct {
+	Query       string  `json:"query"`
+	Searches    int     `json:"searches"`
+	ZeroResults int     `json:"zero_results"`
+	Clicks      int     `json:"clicks"`
+	CTR         float64 `json:"ctr"`
+	AvgPosition float64 `json:"avg_click_position,omitempty"`
+
+	positions int
+}
+
+type FacetUsage struct {
+	Name   string            `json:"name"`
+	Count  int               `json:"count"`
+	Values []*FacetUsageItem `json:"values"`
+}
+
+type FacetUsageItem struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type AnalyticsReport struct {
+	Since                time.Time     `json:"since"`
+	Until                time.Time     `json:"until"`
+	Searches             int           `json:"searches"`
+	ZeroResultSearches   int           `json:"zero_result_searches"`
+	Clicks               int           `json:"clicks"`
+	TopQueries           []*QueryStats `json:"top_queries"`
+	TopZeroResultQueries []*QueryStats `json:"top_zero_result_queries"`
+	FacetUsage           []*FacetUsage `json:"facet_usage"`
+}
+
+// Report summarizes the events since the time, listing the most searched
+// queries and facets up to the size.  Clicks count towards the queries
+// searched in the window.
+func (s *AnalyticsStore) Report(since time.Time, size int) *AnalyticsReport {
+	rv := &AnalyticsReport{
+		Since: since,
+		Until: time.Now().UTC(),
+	}
+	queries := make(map[string]*QueryStats)
+	facets := make(map[string]map[string]int)
+	queryStats := func(query string) *QueryStats {
+		stats, ok := queries[query]
+		if !ok {
+			stats = &QueryStats{Query: query}
+			queries[query] = stats
+		}
+		return stats
+	}
+
+	s.m.Lock()
+	for _, event := range s.events {
+		if event.Time.Before(since) {
+			continue
+		}
+		switch event.Type {
+		case eventSearch:
+			rv.Searches++
+			stats := queryStats(event.Query)
+			stats.Searches++
+			if event.Total == 0 {
+				rv.ZeroResultSearches++
+				stats.ZeroResults++
+			}
+			for _, filter := range event.Filters {
+				if facets[filter.Name] == nil {
+					facets[filter.Name] = make(map[string]int)
+				}
+				facets[filter.Name][filter.Value]++
+			}
+		case eventClick:
+			rv.Clicks++
+			stats := queryStats(event.Query)
+			stats.Clicks++
+			stats.positions += event.Position
+		}
+	}
+	s.m.Unlock()
+
+	var searched, zeroResults []*QueryStats
+	for _, stats := range queries {
+		if stats.Searches > 0 {
+			stats.CTR = float64(stats.Clicks) / float64(stats.Searches)
+		}
+		if stats.Clicks > 0 {
+			stats.AvgPosition = float64(stats.positions) / float64(stats.Clicks)
+		}
+		// browsing by facets without a query is counted in facet usage
+		if stats.Query == "" || stats.Searches == 0 {
+			continue
+		}
+		searched = append(searched, stats)
+		if stats.ZeroResults > 0 {
+			zeroResults = append(zeroResults, stats)
+		}
+	}
+	rv.TopQueries = topQueries(searched, size, func(stats *QueryStats) int { return stats.Searches })
+	rv.TopZeroResultQueries = topQueries(zeroResults, size, func(stats *QueryStats) int { return stats.ZeroResults })
+	rv.FacetUsage = facetUsage(facets, size)
+	return rv
+}
+
+// topQueries sorts the queries by the count, then by query, and keeps the
+// first size
+func topQueries(queries []*QueryStats, size int, count func(stats *QueryStats) int) []*QueryStats {
+	sort.Slice(queries, func(i, j int) bool {
+		if count(queries[i]) != count(queries[j]) {
+			return count(queries[i]) > count(queries[j])
+		}
+		return queries[i].Query < queries[j].Query
+	})
+	if len(queries) > size {
+		queries = queries[:size]
+	}
+	if queries == nil {
+		queries = []*QueryStats{}
+	}
+	return queries
+}
+
+// facetUsage lists the facets filtered on, most used first, with their
+// most used values up to the size
+func facetUsage(facets map[string]map[string]int, size int) []*FacetUsage {
+	rv := make([]*FacetUsage, 0, len(facets))
+	for name, values := range facets {
+		usage := &FacetUsage{Name: name}
+		for value, count := range values {
+			usage.Count += count
+			usage.Values = append(usage.Values, &FacetUsageItem{Value: value, Count: count})
+		}
+		sort.Slice(usage.Values, func(i, j int) bool {
+			if usage.Values[i].Count != usage.Values[j].Count {
+				return usage.Values[i].Count > usage.Values[j].Count
+			}
+			return usage.Values[i].Value < usage.Values[j].Value
+		})
+		if len(usage.Values) > size {
+			usage.Values = usage.Values[:size]
+		}
+		rv = append(rv, usage)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Count != rv[j].Count {
+			return rv[i].Count > rv[j].Count
+		}
+		return rv[i].Name < rv[j].Name
+	})
+	return rv
+}
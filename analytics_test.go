@@ -0,0 +1,178 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyticsReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analytics.jsonl")
+	// an event from before the retention period is not reported
+	err := ioutil.WriteFile(path, []byte(`{"type":"search","time":"2020-01-01T00:00:00Z","query":"old","total":1}`+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenAnalyticsStore(path, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("expected the expired event to be dropped from the file, got: %s", stored)
+	}
+
+	porter := []*Filter{{Name: "style-facet", Value: "Porter"}, {Name: "type", Value: "beer"}}
+	searches := []struct {
+		r     *SearchRequest
+		total uint64
+	}{
+		{r: &SearchRequest{Query: "Stout"}, total: 500},
+		{r: &SearchRequest{Query: " stout  "}, total: 500},
+		{r: &SearchRequest{Query: "stout", Page: 2}, total: 500},
+		{r: &SearchRequest{Query: "guiness", Filters: porter}, total: 0},
+		{r: &SearchRequest{Query: "zymurgy"}, total: 0},
+		{r: &SearchRequest{Query: "guiness"}, total: 0},
+		{r: &SearchRequest{Filters: porter[:1]}, total: 40},
+	}
+	for _, search := range searches {
+		err = store.RecordSearch(search.r, search.total)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, position := range []int{1, 4} {
+		err = store.RecordClick("STOUT", "guinness-extra-stout", position)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as left by a crash while appending
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString(`{"type":"search","ti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events are reported once reloaded, without the partial line
+	store, err = OpenAnalyticsStore(path, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(stored), "\n"); lines != 8 || !strings.HasSuffix(string(stored), "}\n") {
+		t.Errorf("expected the 8 events without the partial line, got: %s", stored)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	report := store.Report(time.Now().Add(-time.Hour), 2)
+
+	if report.Searches != 6 || report.ZeroResultSearches != 3 || report.Clicks != 2 {
+		t.Errorf("expected 6 searches, 3 with zero results and 2 clicks, got %d, %d and %d",
+			report.Searches, report.ZeroResultSearches, report.Clicks)
+	}
+	expectTop := []*QueryStats{
+		{Query: "guiness", Searches: 2, ZeroResults: 2},
+		{Query: "stout", Searches: 2, Clicks: 2, CTR: 1, AvgPosition: 2.5, positions: 5},
+	}
+	if !reflect.DeepEqual(report.TopQueries, expectTop) {
+		t.Errorf("expected top queries: %+v, got: %+v", expectTop, report.TopQueries)
+	}
+	expectZero := []*QueryStats{
+		{Query: "guiness", Searches: 2, ZeroResults: 2},
+		{Query: "zymurgy", Searches: 1, ZeroResults: 1},
+	}
+	if !reflect.DeepEqual(report.TopZeroResultQueries, expectZero) {
+		t.Errorf("expected top zero-result queries: %+v, got: %+v", expectZero, report.TopZeroResultQueries)
+	}
+	expectFacets := []*FacetUsage{
+		{Name: "style-facet", Count: 2, Values: []*FacetUsageItem{{Value: "Porter", Count: 2}}},
+		{Name: "type", Count: 1, Values: []*FacetUsageItem{{Value: "beer", Count: 1}}},
+	}
+	if !reflect.DeepEqual(report.FacetUsage, expectFacets) {
+		t.Errorf("expected facet usage: %+v, got: %+v", expectFacets, report.FacetUsage)
+	}
+
+	report = store.Report(time.Now().Add(time.Hour), 2)
+	if report.Searches != 0 || len(report.TopQueries) != 0 {
+		t.Errorf("expected no searches after the window, got: %+v", report)
+	}
+}
+
+func TestAnalyticsCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analytics.jsonl")
+	store, err := OpenAnalyticsStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	countLines := func() int {
+		stored, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(stored), "\n")
+	}
+
+	now := time.Now().UTC()
+	for _, at := range []time.Time{now.Add(-3 * time.Hour), now.Add(-170 * time.Minute),
+		now.Add(-160 * time.Minute)} {
+		err = store.record(&AnalyticsEvent{Type: eventSearch, Time: at, Query: "old"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := countLines(); lines != 3 {
+		t.Fatalf("expected 3 events appended, got %d", lines)
+	}
+
+	// the expired events are dropped from the file, and recording continues
+	// in the compacted file
+	for i := 0; i < 2; i++ {
+		err = store.record(&AnalyticsEvent{Type: eventSearch, Time: now, Query: "new"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := countLines(); lines != 2 {
+		t.Errorf("expected the 2 events within the retention period, got %d", lines)
+	}
+}
@@ -17,17 +17,51 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis"
+	"github.com/blugelabs/bluge/analysis/token"
+	"github.com/blugelabs/bluge/analysis/tokenizer"
 )
 
+const nameSuggestField = "name-suggest"
+const nameSortField = "name-sort"
+const suggestMaxGram = 20
+
+// suggestAnalyzer indexes every prefix of every word in the name, so that
+// partially typed words match, with or without their accents
+var suggestAnalyzer = &analysis.Analyzer{
+	Tokenizer: tokenizer.NewUnicodeTokenizer(),
+	TokenFilters: []analysis.TokenFilter{
+		token.NewLowerCaseFilter(),
+		foldingFilter{},
+		token.NewEdgeNgramFilter(token.FRONT, 1, suggestMaxGram),
+	},
+}
+
+// suggestQueryAnalyzer must agree with suggestAnalyzer, words longer than
+// the largest prefix indexed are truncated so they still match
+var suggestQueryAnalyzer = &analysis.Analyzer{
+	Tokenizer: tokenizer.NewUnicodeTokenizer(),
+	TokenFilters: []analysis.TokenFilter{
+		token.NewLowerCaseFilter(),
+		foldingFilter{},
+		token.NewTruncateTokenFilter(suggestMaxGram),
+	},
+}
+
 type Base struct {
 	ID          string   `json:"-"`
 	Type        string   `json:"type"`
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	Updated     DateTime `json:"updated,omitempty"`
+
+	// Language analyzes the name and description, when language analysis
+	// is enabled
+	Language string `json:"-"`
 }
 
 func (b *Base) Identifier() bluge.Identifier {
@@ -38,9 +72,13 @@ func (b *Base) Document(jsonBytes []byte) *bluge.Document {
 	doc := bluge.NewDocument(b.ID).
 		AddField(bluge.NewStoredOnlyField("_source", jsonBytes)).
 		AddField(bluge.NewKeywordField("type", b.Type)).
-		AddField(bluge.NewTextField("name", b.Name)).
-		AddField(bluge.NewTextField("desc", b.Description).SearchTermPositions()).
-		AddField(bluge.NewDateTimeField("updated", time.Time(b.Updated)))
+		AddField(textAnalysis.TextField("name", b.Name, b.Language).HighlightMatches()).
+		AddField(bluge.NewTextField(nameSuggestField, b.Name).WithAnalyzer(suggestAnalyzer).StoreValue()).
+		AddField(bluge.NewKeywordField(nameSortField, strings.ToLower(b.Name)).Sortable()).
+		AddField(textAnalysis.TextField("desc", b.Description, b.Language).SearchTermPositions()).
+		AddField(bluge.NewDateTimeField("updated", time.Time(b.Updated))).
+		AddField(newSpellingField(b.Name)).
+		AddField(newSpellingField(b.Description))
 	return doc
 }
 
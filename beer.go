@@ -42,7 +42,7 @@ func NewBeer(id string) *Beer {
 func (b *Beer) Document(jsonBytes []byte) (*bluge.Document, error) {
 	doc := b.Base.Document(jsonBytes)
 
-	doc.AddField(bluge.NewKeywordField("brewery_id", b.BreweryID)).
+	doc.AddField(bluge.NewKeywordField("brewery_id", b.BreweryID).Aggregatable()).
 		AddField(bluge.NewNumericField("abv", b.ABV)).
 		AddField(bluge.NewNumericField("ibu", b.IBU)).
 		AddField(bluge.NewNumericField("srm", b.SRM))
@@ -50,10 +50,12 @@ func (b *Beer) Document(jsonBytes []byte) (*bluge.Document, error) {
 	// convert UPC numeric to text
 	doc.AddField(bluge.NewKeywordField("upc", strconv.Itoa(int(b.UPC))))
 
-	doc.AddField(bluge.NewTextField("category", b.Category))
-	doc.AddField(bluge.NewKeywordField("category-facet", b.Category))
-	doc.AddField(bluge.NewTextField("style", b.Style).Sortable().Aggregatable())
+	doc.AddField(textAnalysis.TextField("category", b.Category, b.Language))
+	doc.AddField(bluge.NewKeywordField("category-facet", b.Category).Aggregatable())
+	doc.AddField(textAnalysis.TextField("style", b.Style, b.Language).HighlightMatches().Sortable().Aggregatable())
 	doc.AddField(bluge.NewKeywordField("style-facet", b.Style).Sortable().Aggregatable())
+	doc.AddField(newSpellingField(b.Category)).
+		AddField(newSpellingField(b.Style))
 
 	doc.AddField(bluge.NewCompositeFieldIncluding("_all", []string{"name", "desc", "category", "style"}))
 
@@ -15,7 +15,12 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
 	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
 )
 
 type GeoPoint struct {
@@ -34,6 +39,8 @@ type Brewery struct {
 	Website string   `json:"website"`
 	Address []string `json:"address"`
 	Geo     GeoPoint ` json:"geo"`
+
+	Stats *BreweryStats `json:"stats,omitempty"`
 }
 
 func NewBrewery(id string) *Brewery {
@@ -45,20 +52,117 @@ func NewBrewery(id string) *Brewery {
 }
 
 func (b *Brewery) Document(jsonBytes []byte) (*bluge.Document, error) {
-	doc := b.Base.Document(jsonBytes)
+	source, err := b.sourceWithStats(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	// breweries are analyzed in the language of their country
+	b.Language = languageForCountry(b.Country)
+	doc := b.Base.Document(source)
 
-	doc.AddField(bluge.NewTextField("city", b.City))
-	doc.AddField(bluge.NewTextField("state", b.State))
-	doc.AddField(bluge.NewTextField("country", b.Country))
+	doc.AddField(textAnalysis.TextField("city", b.City, b.Language))
+	doc.AddField(textAnalysis.TextField("state", b.State, b.Language))
+	doc.AddField(textAnalysis.TextField("country", b.Country, b.Language))
+	doc.AddField(newSpellingField(b.City)).
+		AddField(newSpellingField(b.Country))
+	doc.AddField(bluge.NewKeywordField("city-facet", b.City).Aggregatable())
+	doc.AddField(bluge.NewKeywordField("state-facet", b.State).Aggregatable())
+	doc.AddField(bluge.NewKeywordField("country-facet", b.Country).Aggregatable())
 	doc.AddField(bluge.NewKeywordField("code", b.Code))
 	doc.AddField(bluge.NewKeywordField("phone", b.Phone))
-	doc.AddField(bluge.NewTextField("website", b.Website))
+	doc.AddField(textAnalysis.TextField("website", b.Website, b.Language))
 	for _, addr := range b.Address {
-		doc.AddField(bluge.NewTextField("address", addr).SearchTermPositions())
+		doc.AddField(newAddressField(addr))
 	}
-	doc.AddField(bluge.NewGeoPointField("location", b.Geo.Lon, b.Geo.Lat))
+	doc.AddField(bluge.NewGeoPointField(locationField, b.Geo.Lon, b.Geo.Lat))
 
 	doc.AddField(bluge.NewCompositeFieldIncluding("_all", []string{"name", "desc", "city", "state", "country", "address"}))
 
+	if b.Stats != nil {
+		b.Stats.addFields(doc)
+	}
+
 	return doc, nil
 }
+
+// sourceWithStats stores the stats in the source, replacing any stats it
+// already had, so that they are returned along with the brewery
+func (b *Brewery) sourceWithStats(jsonBytes []byte) ([]byte, error) {
+	if b.Stats == nil {
+		return jsonBytes, nil
+	}
+	var source map[string]json.RawMessage
+	err := json.Unmarshal(jsonBytes, &source)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing brewery source: %v", err)
+	}
+	source["stats"], err = json.Marshal(b.Stats)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding brewery stats: %v", err)
+	}
+	return json.Marshal(source)
+}
+
+func (s *BreweryStats) addFields(doc *bluge.Document) {
+	doc.AddField(bluge.NewNumericField(beerCountAggregation, float64(s.BeerCount)))
+	if s.ABV != nil {
+		doc.AddField(bluge.NewNumericField("abv_min", s.ABV.Min)).
+			AddField(bluge.NewNumericField("abv_median", s.ABV.Median)).
+			AddField(bluge.NewNumericField("abv_max", s.ABV.Max))
+	}
+	if s.IBU != nil {
+		doc.AddField(bluge.NewNumericField("ibu_min", s.IBU.Min)).
+			AddField(bluge.NewNumericField("ibu_median", s.IBU.Median)).
+			AddField(bluge.NewNumericField("ibu_max", s.IBU.Max))
+	}
+	styles := make([]string, 0, len(s.Styles))
+	for style := range s.Styles {
+		styles = append(styles, style)
+	}
+	sort.Strings(styles)
+	for _, style := range styles {
+		doc.AddField(textAnalysis.TextField("beer_styles", style, ""))
+	}
+	if mainStyle := s.MainStyle(); mainStyle != "" {
+		doc.AddField(textAnalysis.TextField("main_style", mainStyle, ""))
+		doc.AddField(bluge.NewKeywordField(mainStyleAggregation, mainStyle).Aggregatable())
+	}
+	if mainCategory := s.MainCategory(); mainCategory != "" {
+		doc.AddField(bluge.NewKeywordField(mainCategoryAggregation, mainCategory).Aggregatable())
+	}
+}
+
+func newAddressField(addr string) *bluge.TermField {
+	return textAnalysis.TextField("address", addr, "").SearchTermPositions()
+}
+
+// addressLocations splits the term locations of the multi-valued address
+// field into one map per line of the address.  Byte offsets restart with
+// each line, so lines are told apart by position, analyzing each line the
+// same way the document was analyzed.
+func (b *Brewery) addressLocations(tlm search.TermLocationMap) []search.TermLocationMap {
+	if len(tlm) == 0 {
+		return nil
+	}
+	rv := make([]search.TermLocationMap, len(b.Address))
+	var offset int
+	for i, addr := range b.Address {
+		field := newAddressField(addr)
+		if offset > 0 {
+			offset += field.PositionIncrementGap()
+		}
+		start := offset
+		offset = field.Analyze(offset)
+		for term, locations := range tlm {
+			for _, location := range locations {
+				if location.Pos > start && location.Pos <= offset {
+					if rv[i] == nil {
+						rv[i] = make(search.TermLocationMap)
+					}
+					rv[i].AddLocation(term, location)
+				}
+			}
+		}
+	}
+	return rv
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"html"
+	"strings"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/highlight"
+)
+
+const highlightBefore = "<mark>"
+const highlightAfter = "</mark>"
+const descFragments = 3
+
+var highlighter = highlight.NewSimpleHighlighter(highlight.NewSimpleFragmenter(),
+	&escapingFragmentFormatter{}, highlight.DefaultSeparator)
+
+// escapingFragmentFormatter marks up matched terms like the bluge HTML
+// formatter, but also escapes the original text so fragments are safe to
+// insert into a page
+type escapingFragmentFormatter struct{}
+
+func (e *escapingFragmentFormatter) Format(f *highlight.Fragment, orderedTermLocations highlight.TermLocations) string {
+	var rv strings.Builder
+	curr := f.Start
+	for _, termLocation := range orderedTermLocations {
+		if termLocation == nil {
+			continue
+		}
+		if termLocation.Start < curr {
+			continue
+		}
+		if termLocation.End > f.End {
+			break
+		}
+		rv.WriteString(html.EscapeString(string(f.Orig[curr:termLocation.Start])))
+		rv.WriteString(highlightBefore)
+		rv.WriteString(html.EscapeString(string(f.Orig[termLocation.Start:termLocation.End])))
+		rv.WriteString(highlightAfter)
+		curr = termLocation.End
+	}
+	rv.WriteString(html.EscapeString(string(f.Orig[curr:f.End])))
+	return rv.String()
+}
+
+// highlightFragments returns HTML-safe fragments of the name, description,
+// style and address showing where the query matched
+func highlightFragments(doc Indexable, locations search.FieldTermLocationMap) map[string][]string {
+	if len(locations) == 0 {
+		return nil
+	}
+	rv := make(map[string][]string)
+	addFragments := func(field, orig string, num int) {
+		tlm := locations[field]
+		if len(tlm) == 0 || orig == "" {
+			return
+		}
+		fragments := highlighter.BestFragments(tlm, []byte(orig), num)
+		if len(fragments) > 0 {
+			rv[field] = append(rv[field], fragments...)
+		}
+	}
+
+	switch d := doc.(type) {
+	case *Beer:
+		addFragments("name", d.Name, 1)
+		addFragments("desc", d.Description, descFragments)
+		addFragments("style", d.Style, 1)
+	case *Brewery:
+		addFragments("name", d.Name, 1)
+		addFragments("desc", d.Description, descFragments)
+		for i, tlm := range d.addressLocations(locations["address"]) {
+			if len(tlm) > 0 {
+				rv["address"] = append(rv["address"], highlighter.BestFragment(tlm, []byte(d.Address[i])))
+			}
+		}
+	}
+
+	if len(rv) == 0 {
+		return nil
+	}
+	return rv
+}
@@ -0,0 +1,95 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestHighlightFragments(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		query    string
+		expect   map[string][]string
+	}{
+		{
+			name:     "address lines",
+			filename: "cains.json",
+			query:    "stanhope",
+			expect: map[string][]string{
+				"address": {"<mark>Stanhope</mark> St"},
+			},
+		},
+		{
+			name:     "name and style",
+			filename: "yuengling_son_brewing-yuengling_porter.json",
+			query:    "porter",
+			expect: map[string][]string{
+				"name":  {"Yuengling <mark>Porter</mark>"},
+				"style": {"<mark>Porter</mark>"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			indexWriter, err := bluge.OpenWriter(bluge.InMemoryOnlyConfig())
+			if err != nil {
+				t.Fatalf("error opening index: %v", err)
+			}
+			obj, doc, err := parseAndBuildDoc("data", test.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = indexWriter.Update(doc.ID(), doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			indexReader, err := indexWriter.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = indexReader.Close()
+			}()
+
+			q, err := parseQueryString(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dmi, err := indexReader.Search(context.Background(), bluge.NewTopNSearch(1, q).IncludeLocations())
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err := dmi.Next()
+			if err != nil || next == nil {
+				t.Fatalf("expected a match, got: %v", err)
+			}
+
+			fragments := highlightFragments(obj, next.Locations)
+			for field, expect := range test.expect {
+				if !reflect.DeepEqual(fragments[field], expect) {
+					t.Errorf("expected %s fragments: %q, got: %q", field, expect, fragments[field])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/aggregations"
+)
+
+// histogramResolution is the width of the narrow buckets values are
+// counted in before an automatic interval is chosen
+const histogramResolution = 0.1
+
+// histogramEpsilon keeps values landing exactly on a bucket boundary in
+// the bucket above, despite floating point error
+const histogramEpsilon = 1e-9
+
+const defaultHistogramBuckets = 10
+const maxHistogramBuckets = 1000
+
+// histogramAggregation counts the values of a numeric field in buckets of
+// equal width, named like 6-9, from the start of the bucket inclusive to
+// the end exclusive.  Without an interval, values are first counted in
+// narrow buckets, which are combined once the range of values is known,
+// into about the requested number of buckets of a round width.
+type histogramAggregation struct {
+	src          search.NumericValuesSource
+	interval     float64
+	buckets      int
+	aggregations map[string]search.Aggregation
+}
+
+func newHistogramAggregation(src search.NumericValuesSource, interval float64, buckets int) *histogramAggregation {
+	if buckets < 1 {
+		buckets = defaultHistogramBuckets
+	}
+	return &histogramAggregation{
+		src:      src,
+		interval: interval,
+		buckets:  buckets,
+		aggregations: map[string]search.Aggregation{
+			"count": aggregations.CountMatches(),
+		},
+	}
+}
+
+func (h *histogramAggregation) Fields() []string {
+	rv := h.src.Fields()
+	for _, agg := range h.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+func (h *histogramAggregation) AddAggregation(name string, agg search.Aggregation) {
+	h.aggregations[name] = agg
+}
+
+func (h *histogramAggregation) Calculator() search.Calculator {
+	rv := &histogramCalculator{
+		src:          h.src,
+		step:         h.interval,
+		target:       h.buckets,
+		aggregations: h.aggregations,
+		bucketsMap:   make(map[int64]*search.Bucket),
+	}
+	if rv.step <= 0 {
+		rv.step = histogramResolution
+		rv.auto = true
+	}
+	return rv
+}
+
+type histogramCalculator struct {
+	src          search.NumericValuesSource
+	step         float64
+	auto         bool
+	target       int
+	aggregations map[string]search.Aggregation
+
+	bucketsMap  map[int64]*search.Bucket
+	bucketsList []*search.Bucket
+}
+
+func (c *histogramCalculator) Consume(d *search.DocumentMatch) {
+	for _, val := range c.src.Numbers(d) {
+		i := int64(math.Floor(val/c.step + histogramEpsilon))
+		bucket, ok := c.bucketsMap[i]
+		if !ok {
+			bucket = search.NewBucket("", c.aggregations)
+			c.bucketsMap[i] = bucket
+		}
+		bucket.Consume(d)
+	}
+}
+
+func (c *histogramCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*histogramCalculator); ok {
+		for i, otherBucket := range other.bucketsMap {
+			if bucket, ok := c.bucketsMap[i]; ok {
+				bucket.Merge(otherBucket)
+			} else {
+				c.bucketsMap[i] = otherBucket
+			}
+		}
+	}
+}
+
+func (c *histogramCalculator) Finish() {
+	if len(c.bucketsMap) == 0 {
+		return
+	}
+
+	step := c.step
+	bucketsMap := c.bucketsMap
+	if c.auto {
+		low, high := bucketRange(bucketsMap)
+		step = niceInterval(float64(high-low+1)*c.step/float64(c.target), c.step)
+		bucketsMap = make(map[int64]*search.Bucket)
+		for i, bucket := range c.bucketsMap {
+			j := int64(math.Floor(float64(i)*c.step/step + histogramEpsilon))
+			if merged, ok := bucketsMap[j]; ok {
+				merged.Merge(bucket)
+			} else {
+				bucketsMap[j] = bucket
+			}
+		}
+	}
+
+	// include empty buckets between the lowest and highest values, so the
+	// buckets describe the whole distribution, unless there are too many
+	low, high := bucketRange(bucketsMap)
+	var indexes []int64
+	if high-low < maxHistogramBuckets {
+		for i := low; i <= high; i++ {
+			indexes = append(indexes, i)
+		}
+	} else {
+		for i := range bucketsMap {
+			indexes = append(indexes, i)
+		}
+		sort.Slice(indexes, func(a, b int) bool {
+			return indexes[a] < indexes[b]
+		})
+	}
+
+	c.bucketsList = c.bucketsList[:0]
+	for _, i := range indexes {
+		named := search.NewBucket(histogramBucketName(float64(i)*step, float64(i+1)*step), c.aggregations)
+		if bucket, ok := bucketsMap[i]; ok {
+			named.Merge(bucket)
+		}
+		named.Finish()
+		c.bucketsList = append(c.bucketsList, named)
+	}
+}
+
+func (c *histogramCalculator) Buckets() []*search.Bucket {
+	return c.bucketsList
+}
+
+func bucketRange(bucketsMap map[int64]*search.Bucket) (low, high int64) {
+	first := true
+	for i := range bucketsMap {
+		if first || i < low {
+			low = i
+		}
+		if first || i > high {
+			high = i
+		}
+		first = false
+	}
+	return low, high
+}
+
+// niceInterval rounds the interval up to 1, 2 or 5 times a power of ten,
+// and to at least the minimum
+func niceInterval(interval, min float64) float64 {
+	if interval <= min {
+		return min
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(interval)))
+	for _, multiple := range []float64{1, 2, 5} {
+		if multiple*magnitude >= interval-histogramEpsilon {
+			return multiple * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func histogramBucketName(start, end float64) string {
+	return formatBound(start) + "-" + formatBound(end)
+}
+
+func formatBound(val float64) string {
+	// drop the floating point error from multiplying by the interval
+	rv := strconv.FormatFloat(val, 'f', 9, 64)
+	rv = strings.TrimRight(strings.TrimRight(rv, "0"), ".")
+	if rv == "-0" {
+		return "0"
+	}
+	return rv
+}
+
+// parseNumericRange parses a range in the form start-end, from the start
+// inclusive to the end exclusive, either may be left out to leave that
+// end of the range open.  Either may be negative, like -10--5.
+func parseNumericRange(in string) (start, end float64, ok bool) {
+	in = strings.TrimSpace(in)
+	dash := rangeSeparator(in)
+	if dash < 0 || in == "-" {
+		return 0, 0, false
+	}
+	start, end = bluge.MinNumeric, bluge.MaxNumeric
+	var err error
+	if startStr := strings.TrimSpace(in[:dash]); startStr != "" {
+		start, err = strconv.ParseFloat(startStr, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	if endStr := strings.TrimSpace(in[dash+1:]); endStr != "" {
+		end, err = strconv.ParseFloat(endStr, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, end, start < end
+}
+
+// rangeSeparator returns the position of the dash between the start and
+// the end of a range, the first dash after a digit or a space, as any other
+// is the sign of a bound.  A leading dash is the separator of a range with
+// an open start.
+func rangeSeparator(in string) int {
+	for i := 1; i < len(in); i++ {
+		if in[i] == '-' && (in[i-1] == ' ' || (in[i-1] >= '0' && in[i-1] <= '9')) {
+			return i
+		}
+	}
+	if strings.HasPrefix(in, "-") {
+		return 0
+	}
+	return -1
+}
+
+const calendarYear = "year"
+const calendarQuarter = "quarter"
+const calendarMonth = "month"
+const calendarWeek = "week"
+
+var calendarIntervals = []string{calendarYear, calendarQuarter, calendarMonth, calendarWeek}
+
+// dateHistogramAggregation counts dates in calendar periods, named like
+// 2010, 2010-Q3, 2010-07 or 2010-W27 for ISO weeks, the same names are
+// accepted as date filters
+type dateHistogramAggregation struct {
+	src          search.DateValuesSource
+	interval     string
+	aggregations map[string]search.Aggregation
+}
+
+func newDateHistogramAggregation(src search.DateValuesSource, interval string) *dateHistogramAggregation {
+	return &dateHistogramAggregation{
+		src:      src,
+		interval: interval,
+		aggregations: map[string]search.Aggregation{
+			"count": aggregations.CountMatches(),
+		},
+	}
+}
+
+func (h *dateHistogramAggregation) Fields() []string {
+	rv := h.src.Fields()
+	for _, agg := range h.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+func (h *dateHistogramAggregation) AddAggregation(name string, agg search.Aggregation) {
+	h.aggregations[name] = agg
+}
+
+func (h *dateHistogramAggregation) Calculator() search.Calculator {
+	return &dateHistogramCalculator{
+		src:          h.src,
+		interval:     h.interval,
+		aggregations: h.aggregations,
+		bucketsMap:   make(map[time.Time]*search.Bucket),
+	}
+}
+
+type dateHistogramCalculator struct {
+	src          search.DateValuesSource
+	interval     string
+	aggregations map[string]search.Aggregation
+
+	bucketsMap  map[time.Time]*search.Bucket
+	bucketsList []*search.Bucket
+}
+
+func (c *dateHistogramCalculator) Consume(d *search.DocumentMatch) {
+	for _, date := range c.src.Dates(d) {
+		start := periodStart(date, c.interval)
+		bucket, ok := c.bucketsMap[start]
+		if !ok {
+			bucket = search.NewBucket("", c.aggregations)
+			c.bucketsMap[start] = bucket
+		}
+		bucket.Consume(d)
+	}
+}
+
+func (c *dateHistogramCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*dateHistogramCalculator); ok {
+		for start, otherBucket := range other.bucketsMap {
+			if bucket, ok := c.bucketsMap[start]; ok {
+				bucket.Merge(otherBucket)
+			} else {
+				c.bucketsMap[start] = otherBucket
+			}
+		}
+	}
+}
+
+func (c *dateHistogramCalculator) Finish() {
+	starts := make([]time.Time, 0, len(c.bucketsMap))
+	for start := range c.bucketsMap {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(a, b int) bool {
+		return starts[a].Before(starts[b])
+	})
+
+	// include the empty periods between the first and last dates, unless
+	// there are too many
+	if len(starts) > 1 {
+		var all []time.Time
+		last := starts[len(starts)-1]
+		for start := starts[0]; !start.After(last) && len(all) <= maxHistogramBuckets; start = periodEnd(start, c.interval) {
+			all = append(all, start)
+		}
+		if len(all) <= maxHistogramBuckets {
+			starts = all
+		}
+	}
+
+	c.bucketsList = c.bucketsList[:0]
+	for _, start := range starts {
+		named := search.NewBucket(periodName(start, c.interval), c.aggregations)
+		if bucket, ok := c.bucketsMap[start]; ok {
+			named.Merge(bucket)
+		}
+		named.Finish()
+		c.bucketsList = append(c.bucketsList, named)
+	}
+}
+
+func (c *dateHistogramCalculator) Buckets() []*search.Bucket {
+	return c.bucketsList
+}
+
+// periodStart returns the start of the calendar period containing the
+// date, in UTC, weeks start on Monday
+func periodStart(date time.Time, interval string) time.Time {
+	date = date.UTC()
+	year, month, day := date.Date()
+	switch interval {
+	case calendarYear:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	case calendarQuarter:
+		return time.Date(year, (month-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+	case calendarMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		daysSinceMonday := (int(date.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func periodEnd(start time.Time, interval string) time.Time {
+	switch interval {
+	case calendarYear:
+		return start.AddDate(1, 0, 0)
+	case calendarQuarter:
+		return start.AddDate(0, 3, 0)
+	case calendarMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 7)
+	}
+}
+
+func periodName(start time.Time, interval string) string {
+	switch interval {
+	case calendarYear:
+		return start.Format("2006")
+	case calendarQuarter:
+		return fmt.Sprintf("%d-Q%d", start.Year(), (int(start.Month())-1)/3+1)
+	case calendarMonth:
+		return start.Format("2006-01")
+	default:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+}
+
+var periodPatterns = []struct {
+	re       *regexp.Regexp
+	interval string
+}{
+	{re: regexp.MustCompile(`^(\d{4})$`), interval: calendarYear},
+	{re: regexp.MustCompile(`^(\d{4})-Q([1-4])$`), interval: calendarQuarter},
+	{re: regexp.MustCompile(`^(\d{4})-(\d{2})$`), interval: calendarMonth},
+	{re: regexp.MustCompile(`^(\d{4})-W(\d{2})$`), interval: calendarWeek},
+}
+
+// parseDateRange parses a date filter, either a calendar period named like
+// the date histogram buckets, a single day like 2010-07-04, or a range of
+// dates in the form start..end, from the start inclusive to the end
+// exclusive, either may be left out to leave that end of the range open
+func parseDateRange(in string) (start, end time.Time, ok bool) {
+	if dots := strings.Index(in, ".."); dots >= 0 {
+		var err error
+		start, err = parseDSLDate(strings.TrimSpace(in[:dots]))
+		if err != nil {
+			return start, end, false
+		}
+		end, err = parseDSLDate(strings.TrimSpace(in[dots+2:]))
+		if err != nil {
+			return start, end, false
+		}
+		if start.IsZero() && end.IsZero() {
+			return start, end, false
+		}
+		return start, end, start.IsZero() || end.IsZero() || start.Before(end)
+	}
+
+	if day, err := time.Parse("2006-01-02", in); err == nil {
+		return day, day.AddDate(0, 0, 1), true
+	}
+
+	for _, pattern := range periodPatterns {
+		match := pattern.re.FindStringSubmatch(in)
+		if match == nil {
+			continue
+		}
+		year, _ := strconv.Atoi(match[1])
+		yearStart := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		switch pattern.interval {
+		case calendarYear:
+			start = yearStart
+		case calendarQuarter:
+			quarter, _ := strconv.Atoi(match[2])
+			start = yearStart.AddDate(0, 3*(quarter-1), 0)
+		case calendarMonth:
+			month, _ := strconv.Atoi(match[2])
+			if month < 1 || month > 12 {
+				return start, end, false
+			}
+			start = yearStart.AddDate(0, month-1, 0)
+		case calendarWeek:
+			week, _ := strconv.Atoi(match[2])
+			// ISO week 1 is the week containing January 4th
+			start = periodStart(time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC), calendarWeek).
+				AddDate(0, 0, 7*(week-1))
+			if y, w := start.ISOWeek(); y != year || w != week {
+				return start, end, false
+			}
+		}
+		return start, periodEnd(start, pattern.interval), true
+	}
+	return start, end, false
+}
@@ -0,0 +1,240 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+)
+
+// numbersSource returns the number at the position of the document match
+type numbersSource []float64
+
+func (n numbersSource) Fields() []string {
+	return nil
+}
+
+func (n numbersSource) Numbers(match *search.DocumentMatch) []float64 {
+	return []float64{n[match.Number]}
+}
+
+func TestHistogramAggregation(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   numbersSource
+		interval float64
+		buckets  int
+		expect   map[string]uint64
+	}{
+		{
+			name:     "interval",
+			values:   numbersSource{4.5, 5, 5.5, 6, 7.9, 9},
+			interval: 2,
+			expect: map[string]uint64{
+				"4-6":  3,
+				"6-8":  2,
+				"8-10": 1,
+			},
+		},
+		{
+			name:     "fractional interval",
+			values:   numbersSource{0.3, 0.6, 0.9},
+			interval: 0.3,
+			expect: map[string]uint64{
+				"0.3-0.6": 1,
+				"0.6-0.9": 1,
+				"0.9-1.2": 1,
+			},
+		},
+		{
+			name:    "automatic",
+			values:  numbersSource{3.2, 4.7, 5.5, 12, 21},
+			buckets: 4,
+			expect: map[string]uint64{
+				"0-5":   2,
+				"5-10":  1,
+				"10-15": 1,
+				"15-20": 0,
+				"20-25": 1,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			calc := newHistogramAggregation(test.values, test.interval, test.buckets).Calculator()
+			for i := range test.values {
+				calc.Consume(&search.DocumentMatch{Number: uint64(i)})
+			}
+			calc.Finish()
+
+			got := make(map[string]uint64)
+			for _, bucket := range calc.(search.BucketCalculator).Buckets() {
+				got[bucket.Name()] = bucket.Count()
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected buckets: %v, got: %v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestParseNumericRange(t *testing.T) {
+	tests := []struct {
+		in          string
+		expectStart float64
+		expectEnd   float64
+		expectOK    bool
+	}{
+		{in: "6-9", expectStart: 6, expectEnd: 9, expectOK: true},
+		{in: "4.5-5.25", expectStart: 4.5, expectEnd: 5.25, expectOK: true},
+		{in: "10-", expectStart: 10, expectEnd: bluge.MaxNumeric, expectOK: true},
+		{in: "-3", expectStart: bluge.MinNumeric, expectEnd: 3, expectOK: true},
+		{in: "-5-10", expectStart: -5, expectEnd: 10, expectOK: true},
+		{in: "-10--5", expectStart: -10, expectEnd: -5, expectOK: true},
+		{in: "-10 - -5", expectStart: -10, expectEnd: -5, expectOK: true},
+		{in: "-5-", expectStart: -5, expectEnd: bluge.MaxNumeric, expectOK: true},
+		{in: "--5", expectStart: bluge.MinNumeric, expectEnd: -5, expectOK: true},
+		{in: "1e-3-2", expectStart: 0.001, expectEnd: 2, expectOK: true},
+		{in: "-"},
+		{in: "high"},
+		{in: "9-6"},
+		{in: "-5--10"},
+		{in: "a-b"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.in, func(t *testing.T) {
+			start, end, ok := parseNumericRange(test.in)
+			if ok != test.expectOK {
+				t.Fatalf("expected ok %t, got %t", test.expectOK, ok)
+			}
+			if ok && (start != test.expectStart || end != test.expectEnd) {
+				t.Errorf("expected range %g-%g, got %g-%g", test.expectStart, test.expectEnd, start, end)
+			}
+		})
+	}
+}
+
+// datesSource returns the date at the position of the document match
+type datesSource []time.Time
+
+func (d datesSource) Fields() []string {
+	return nil
+}
+
+func (d datesSource) Dates(match *search.DocumentMatch) []time.Time {
+	return []time.Time{d[match.Number]}
+}
+
+func TestDateHistogramAggregation(t *testing.T) {
+	dates := datesSource{
+		mustTimeParse(rfc3339NoTimezoneNoT, "2010-07-22 20:00:20"),
+		mustTimeParse(rfc3339NoTimezoneNoT, "2010-09-30 23:59:59"),
+		mustTimeParse(rfc3339NoTimezoneNoT, "2011-01-03 08:00:00"),
+	}
+	tests := []struct {
+		interval string
+		expect   []string
+		counts   []uint64
+	}{
+		{
+			interval: calendarYear,
+			expect:   []string{"2010", "2011"},
+			counts:   []uint64{2, 1},
+		},
+		{
+			interval: calendarQuarter,
+			expect:   []string{"2010-Q3", "2010-Q4", "2011-Q1"},
+			counts:   []uint64{2, 0, 1},
+		},
+		{
+			interval: calendarMonth,
+			expect:   []string{"2010-07", "2010-08", "2010-09", "2010-10", "2010-11", "2010-12", "2011-01"},
+			counts:   []uint64{1, 0, 1, 0, 0, 0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.interval, func(t *testing.T) {
+			calc := newDateHistogramAggregation(dates, test.interval).Calculator()
+			for i := range dates {
+				calc.Consume(&search.DocumentMatch{Number: uint64(i)})
+			}
+			calc.Finish()
+
+			var names []string
+			var counts []uint64
+			for _, bucket := range calc.(search.BucketCalculator).Buckets() {
+				names = append(names, bucket.Name())
+				counts = append(counts, bucket.Count())
+			}
+			if !reflect.DeepEqual(names, test.expect) || !reflect.DeepEqual(counts, test.counts) {
+				t.Errorf("expected buckets: %v %v, got: %v %v", test.expect, test.counts, names, counts)
+			}
+		})
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		in          string
+		expectStart string
+		expectEnd   string
+		expectOK    bool
+	}{
+		{in: "2010", expectStart: "2010-01-01", expectEnd: "2011-01-01", expectOK: true},
+		{in: "2010-Q3", expectStart: "2010-07-01", expectEnd: "2010-10-01", expectOK: true},
+		{in: "2010-12", expectStart: "2010-12-01", expectEnd: "2011-01-01", expectOK: true},
+		{in: "2010-W01", expectStart: "2010-01-04", expectEnd: "2010-01-11", expectOK: true},
+		{in: "2009-W53", expectStart: "2009-12-28", expectEnd: "2010-01-04", expectOK: true},
+		{in: "2010-07-04", expectStart: "2010-07-04", expectEnd: "2010-07-05", expectOK: true},
+		{in: "2010-07-01..2010-10-01", expectStart: "2010-07-01", expectEnd: "2010-10-01", expectOK: true},
+		{in: "2011-01-06..", expectStart: "2011-01-06", expectOK: true},
+		{in: "..2011-01-06", expectEnd: "2011-01-06", expectOK: true},
+		{in: ".."},
+		{in: "2010-Q5"},
+		{in: "2010-13"},
+		{in: "2010-W53"},
+		{in: "2010-10-01..2010-07-01"},
+		{in: "recently"},
+	}
+
+	format := func(tt time.Time) string {
+		if tt.IsZero() {
+			return ""
+		}
+		return tt.Format("2006-01-02")
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.in, func(t *testing.T) {
+			start, end, ok := parseDateRange(test.in)
+			if ok != test.expectOK {
+				t.Fatalf("expected ok %t, got %t", test.expectOK, ok)
+			}
+			if ok && (format(start) != test.expectStart || format(end) != test.expectEnd) {
+				t.Errorf("expected range %s..%s, got %s..%s", test.expectStart, test.expectEnd, format(start), format(end))
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const defaultAnalyticsWindow = 7 * 24 * time.Hour
+const defaultAnalyticsSize = 10
+const maxAnalyticsSize = 100
+
+// AnalyticsHandler reports the top queries, top zero-result queries and
+// facet usage over the window URL parameter, a duration like 24h
+type AnalyticsHandler struct {
+	store  *AnalyticsStore
+	logger *log.Logger
+}
+
+func NewAnalyticsHandler(store *AnalyticsStore, logger *log.Logger) *AnalyticsHandler {
+	return &AnalyticsHandler{
+		store:  store,
+		logger: logger,
+	}
+}
+
+func (h *AnalyticsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	window := defaultAnalyticsWindow
+	if windowStr := params.Get("window"); windowStr != "" {
+		var err error
+		window, err = time.ParseDuration(windowStr)
+		if err != nil || window <= 0 {
+			showError(w, req, fmt.Sprintf("window '%s' must be a positive duration, like 24h", windowStr), 400, h.logger)
+			return
+		}
+	}
+	size, err := intParam(params, "size")
+	if err != nil {
+		showError(w, req, err.Error(), 400, h.logger)
+		return
+	}
+	if size < 1 {
+		size = defaultAnalyticsSize
+	}
+	if size > maxAnalyticsSize {
+		size = maxAnalyticsSize
+	}
+
+	mustEncode(w, h.store.Report(time.Now().UTC().Add(-window), size))
+}
+
+// ClickEvent is a click on the hit with the document ID, at its position
+// in the results of the query, starting at 1
+type ClickEvent struct {
+	Query    string `json:"query"`
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+}
+
+// ClickHandler records the clicks on search results posted to it
+type ClickHandler struct {
+	store  *AnalyticsStore
+	logger *log.Logger
+}
+
+func NewClickHandler(store *AnalyticsStore, logger *log.Logger) *ClickHandler {
+	return &ClickHandler{
+		store:  store,
+		logger: logger,
+	}
+}
+
+func (h *ClickHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	requestBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error reading request body: %v", err), 400, h.logger)
+		return
+	}
+	var click ClickEvent
+	err = json.Unmarshal(requestBody, &click)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error parsing click: %v", err), 400, h.logger)
+		return
+	}
+	if click.ID == "" || click.Position < 1 {
+		showError(w, req, "a click needs the id of the document and its position, starting at 1", 400, h.logger)
+		return
+	}
+
+	err = h.store.RecordClick(click.Query, click.ID, click.Position)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error recording click: %v", err), 500, h.logger)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
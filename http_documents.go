@@ -0,0 +1,341 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+	"github.com/gorilla/mux"
+)
+
+// DocumentHandler can get, replace and delete a single beer or brewery
+// over HTTP, the document ID is taken from the {id} route variable.  The
+// stats of the breweries affected by a change are rolled up again, and the
+// beers of a brewery changing language are analyzed in the new language.
+type DocumentHandler struct {
+	docType            string
+	indexWriter        *bluge.Writer
+	beerIndexWriter    *bluge.Writer
+	breweryIndexWriter *bluge.Writer
+	rollup             *BreweryRollup
+	logger             *log.Logger
+}
+
+func NewDocumentHandler(docType string, beerIndexWriter, breweryIndexWriter *bluge.Writer, rollup *BreweryRollup,
+	logger *log.Logger) *DocumentHandler {
+	indexWriter := breweryIndexWriter
+	if docType == typeBeer {
+		indexWriter = beerIndexWriter
+	}
+	return &DocumentHandler{
+		docType:            docType,
+		indexWriter:        indexWriter,
+		beerIndexWriter:    beerIndexWriter,
+		breweryIndexWriter: breweryIndexWriter,
+		rollup:             rollup,
+		logger:             logger,
+	}
+}
+
+func (h *DocumentHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	docID := mux.Vars(req)["id"]
+	if docID == "" {
+		showError(w, req, "document id is required", 400, h.logger)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		h.get(w, req, docID)
+	case http.MethodPut:
+		h.put(w, req, docID)
+	case http.MethodDelete:
+		h.delete(w, req, docID)
+	default:
+		showError(w, req, fmt.Sprintf("method not allowed: %s", req.Method), 405, h.logger)
+	}
+}
+
+func (h *DocumentHandler) get(w http.ResponseWriter, req *http.Request, docID string) {
+	source, err := h.source(docID)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error loading document: %v", err), 500, h.logger)
+		return
+	}
+	if source == nil {
+		showError(w, req, fmt.Sprintf("%s '%s' not found", h.docType, docID), 404, h.logger)
+		return
+	}
+
+	mustEncode(w, json.RawMessage(source))
+}
+
+func (h *DocumentHandler) put(w http.ResponseWriter, req *http.Request, docID string) {
+	requestBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error reading request body: %v", err), 400, h.logger)
+		return
+	}
+
+	if h.docType == typeBeer && !strings.Contains(docID, "-") {
+		showError(w, req, fmt.Sprintf("beer id '%s' must be the brewery id and a name separated by '-'", docID),
+			400, h.logger)
+		return
+	}
+
+	obj, source, err := unmarshalByType(h.docType, docID, requestBody)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error parsing %s: %v", h.docType, err), 400, h.logger)
+		return
+	}
+	err = validateIndexable(h.docType, obj)
+	if err != nil {
+		showError(w, req, err.Error(), 400, h.logger)
+		return
+	}
+
+	if beer, ok := obj.(*Beer); ok {
+		beer.Language, err = beerLanguage(h.breweryIndexWriter, beer.BreweryID)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error loading brewery: %v", err), 500, h.logger)
+			return
+		}
+	}
+	doc, err := obj.Document(source)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error mapping object: %v", err), 400, h.logger)
+		return
+	}
+	previous, err := h.source(docID)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error loading document: %v", err), 500, h.logger)
+		return
+	}
+	err = h.indexWriter.Update(doc.ID(), doc)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error updating index: %v", err), 500, h.logger)
+		return
+	}
+	indexStatus.Indexed()
+	if brewery, ok := obj.(*Brewery); ok {
+		err = h.reanalyzeBeers(brewery, previous)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error reindexing beers: %v", err), 500, h.logger)
+			return
+		}
+	}
+	err = h.refreshStats(docID, previous, obj)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error refreshing brewery stats: %v", err), 500, h.logger)
+		return
+	}
+
+	mustEncode(w, json.RawMessage(source))
+}
+
+func (h *DocumentHandler) delete(w http.ResponseWriter, req *http.Request, docID string) {
+	source, err := h.source(docID)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error loading document: %v", err), 500, h.logger)
+		return
+	}
+	if source == nil {
+		showError(w, req, fmt.Sprintf("%s '%s' not found", h.docType, docID), 404, h.logger)
+		return
+	}
+
+	err = h.indexWriter.Delete(bluge.Identifier(docID))
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error updating index: %v", err), 500, h.logger)
+		return
+	}
+	indexStatus.Indexed()
+	err = h.refreshStats(docID, source, nil)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error refreshing brewery stats: %v", err), 500, h.logger)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshStats rolls up the stats of the breweries a beer belonged to
+// before and after the change, or of a brewery which was replaced
+func (h *DocumentHandler) refreshStats(docID string, previous []byte, current Indexable) error {
+	if h.rollup == nil {
+		return nil
+	}
+	var breweryIDs []string
+	switch o := current.(type) {
+	case *Beer:
+		breweryIDs = append(breweryIDs, o.BreweryID)
+	case *Brewery:
+		breweryIDs = append(breweryIDs, docID)
+	}
+	if previous != nil && h.docType == typeBeer {
+		obj, _, err := unmarshalByType(typeBeer, docID, previous)
+		if err != nil {
+			return err
+		}
+		breweryIDs = append(breweryIDs, obj.(*Beer).BreweryID)
+	}
+	return h.rollup.Refresh(breweryIDs...)
+}
+
+// reanalyzeBeers reindexes the beers of the brewery in the language of its
+// country, when the language differs from the one of the previous brewery
+func (h *DocumentHandler) reanalyzeBeers(brewery *Brewery, previous []byte) error {
+	if !textAnalysis.languages {
+		return nil
+	}
+	var previousLanguage string
+	if previous != nil {
+		obj, _, err := unmarshalByType(typeBrewery, brewery.ID, previous)
+		if err != nil {
+			return err
+		}
+		previousLanguage = languageForCountry(obj.(*Brewery).Country)
+	}
+	language := languageForCountry(brewery.Country)
+	if language == previousLanguage {
+		return nil
+	}
+
+	beerReader, err := h.beerIndexWriter.Reader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = beerReader.Close()
+	}()
+	count, err := beerReader.Count()
+	if err != nil || count == 0 {
+		return err
+	}
+	q := bluge.NewTermQuery(brewery.ID).SetField(breweryAggregation)
+	dmi, err := beerReader.Search(context.Background(), bluge.NewTopNSearch(int(count), q))
+	if err != nil {
+		return err
+	}
+	batch := bluge.NewBatch()
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		var docID string
+		var source []byte
+		err = next.VisitStoredFields(func(field string, value []byte) bool {
+			switch field {
+			case "_id":
+				docID = string(value)
+			case "_source":
+				source = append([]byte(nil), value...)
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("error visiting stored fields: %v", err)
+		}
+		obj, _, err := unmarshalByType(typeBeer, docID, source)
+		if err != nil {
+			return fmt.Errorf("error parsing beer '%s': %v", docID, err)
+		}
+		beer := obj.(*Beer)
+		beer.Language = language
+		doc, err := beer.Document(source)
+		if err != nil {
+			return fmt.Errorf("error mapping beer '%s': %v", docID, err)
+		}
+		batch.Update(doc.ID(), doc)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return err
+	}
+	err = h.beerIndexWriter.Batch(batch)
+	if err != nil {
+		return err
+	}
+	indexStatus.Indexed()
+	return nil
+}
+
+func (h *DocumentHandler) source(docID string) ([]byte, error) {
+	reader, err := h.indexWriter.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	return loadSource(reader, docID)
+}
+
+// loadSource returns the stored _source of the document with the provided
+// ID, or nil if there is no such document
+func loadSource(reader *bluge.Reader, docID string) ([]byte, error) {
+	q := bluge.NewTermQuery(docID).SetField("_id")
+	dmi, err := reader.Search(context.Background(), bluge.NewTopNSearch(1, q))
+	if err != nil {
+		return nil, err
+	}
+	next, err := dmi.Next()
+	if err != nil || next == nil {
+		return nil, err
+	}
+
+	var source []byte
+	err = next.VisitStoredFields(func(field string, value []byte) bool {
+		if field == "_source" {
+			source = append([]byte(nil), value...)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error visiting stored fields: %v", err)
+	}
+	return source, nil
+}
+
+// validateIndexable checks that a document sent over the API is consistent
+// with the route it was sent to
+func validateIndexable(docType string, obj Indexable) error {
+	switch o := obj.(type) {
+	case *Beer:
+		if o.Type != docType {
+			return fmt.Errorf("expected type '%s', got '%s'", docType, o.Type)
+		}
+		if o.Name == "" {
+			return fmt.Errorf("beer name is required")
+		}
+		if o.BreweryID == "" {
+			return fmt.Errorf("beer brewery_id is required")
+		}
+	case *Brewery:
+		if o.Type != docType {
+			return fmt.Errorf("expected type '%s', got '%s'", docType, o.Type)
+		}
+		if o.Name == "" {
+			return fmt.Errorf("brewery name is required")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+	"github.com/gorilla/mux"
+)
+
+func TestDocumentHandler(t *testing.T) {
+	beerIndexWriter, breweryIndexWriter := openTestWriters(t)
+	beers := bluge.NewBatch()
+	breweries := bluge.NewBatch()
+	for _, filename := range []string{"yuengling_son_brewing.json", "yuengling_son_brewing-yuengling_lager.json",
+		"yuengling_son_brewing-yuengling_porter.json"} {
+		obj, doc, err := parseAndBuildDoc("data", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := obj.(*Beer); ok {
+			beers.Update(doc.ID(), doc)
+		} else {
+			breweries.Update(doc.ID(), doc)
+		}
+	}
+	err := beerIndexWriter.Batch(beers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = breweryIndexWriter.Batch(breweries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollup := NewBreweryRollup(beerIndexWriter, breweryIndexWriter)
+	err = rollup.Refresh("yuengling_son_brewing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	router := mux.NewRouter()
+	router.Handle("/api/beers/{id}", NewDocumentHandler(typeBeer, beerIndexWriter, breweryIndexWriter, rollup, logger))
+	router.Handle("/api/breweries/{id}", NewDocumentHandler(typeBrewery, beerIndexWriter, breweryIndexWriter, rollup, logger))
+
+	// the requests are made in order, each seeing the changes of the ones before
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectCode   int
+		expectBody   string
+		expectBeers  int
+		expectMaxABV float64
+	}{
+		{
+			name:         "get",
+			method:       "GET",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_lager",
+			expectCode:   200,
+			expectBody:   `"name":"Yuengling Lager"`,
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "get missing",
+			method:       "GET",
+			path:         "/api/beers/yuengling_son_brewing-no_such_beer",
+			expectCode:   404,
+			expectBody:   "not found",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "put invalid json",
+			method:       "PUT",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_test",
+			body:         `{"name":`,
+			expectCode:   400,
+			expectBody:   "error parsing beer",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "put wrong type",
+			method:       "PUT",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_test",
+			body:         `{"type":"brewery","name":"Yuengling Test","brewery_id":"yuengling_son_brewing"}`,
+			expectCode:   400,
+			expectBody:   "expected type 'beer', got 'brewery'",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "put beer without separator",
+			method:       "PUT",
+			path:         "/api/beers/yuengling_test",
+			body:         `{"type":"beer","name":"Yuengling Test","brewery_id":"yuengling_son_brewing"}`,
+			expectCode:   400,
+			expectBody:   "must be the brewery id and a name separated by '-'",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "put beer without brewery",
+			method:       "PUT",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_test",
+			body:         `{"type":"beer","name":"Yuengling Test"}`,
+			expectCode:   400,
+			expectBody:   "beer brewery_id is required",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "put brewery without name",
+			method:       "PUT",
+			path:         "/api/breweries/yuengling_son_brewing",
+			body:         `{"type":"brewery","city":"Pottsville"}`,
+			expectCode:   400,
+			expectBody:   "brewery name is required",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "put new beer",
+			method:       "PUT",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_test",
+			body:         `{"type":"beer","name":"Yuengling Test","abv":9.5,"brewery_id":"yuengling_son_brewing"}`,
+			expectCode:   200,
+			expectBody:   `"name":"Yuengling Test"`,
+			expectBeers:  3,
+			expectMaxABV: 9.5,
+		},
+		{
+			name:         "put changed beer",
+			method:       "PUT",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_test",
+			body:         `{"type":"beer","name":"Yuengling Test","abv":6.5,"brewery_id":"yuengling_son_brewing"}`,
+			expectCode:   200,
+			expectBody:   `"abv":6.5`,
+			expectBeers:  3,
+			expectMaxABV: 6.5,
+		},
+		{
+			name:         "put brewery keeps stats",
+			method:       "PUT",
+			path:         "/api/breweries/yuengling_son_brewing",
+			body:         `{"type":"brewery","name":"Yuengling","city":"Pottsville","country":"United States"}`,
+			expectCode:   200,
+			expectBody:   `"name":"Yuengling"`,
+			expectBeers:  3,
+			expectMaxABV: 6.5,
+		},
+		{
+			name:         "delete beer",
+			method:       "DELETE",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_test",
+			expectCode:   204,
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "delete missing",
+			method:       "DELETE",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_test",
+			expectCode:   404,
+			expectBody:   "not found",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+		{
+			name:         "method not allowed",
+			method:       "POST",
+			path:         "/api/beers/yuengling_son_brewing-yuengling_lager",
+			expectCode:   405,
+			expectBody:   "method not allowed",
+			expectBeers:  2,
+			expectMaxABV: 4.7,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+			if w.Code != test.expectCode {
+				t.Errorf("expected code %d, got %d: %s", test.expectCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), test.expectBody) {
+				t.Errorf("expected body to contain %s, got: %s", test.expectBody, w.Body.String())
+			}
+			stats := loadBreweryStats(t, breweryIndexWriter, "yuengling_son_brewing")
+			if stats.BeerCount != test.expectBeers || stats.ABV.Max != test.expectMaxABV {
+				t.Errorf("expected %d beers up to %.1f abv, got %d beers and abv: %#v",
+					test.expectBeers, test.expectMaxABV, stats.BeerCount, stats.ABV)
+			}
+		})
+	}
+}
+
+func TestDocumentHandlerBreweryLanguage(t *testing.T) {
+	defer func(prev *TextAnalysis) {
+		textAnalysis = prev
+	}(textAnalysis)
+	textAnalysis = NewTextAnalysis(nil, true)
+
+	beerIndexWriter, breweryIndexWriter := openTestWriters(t)
+	logger := log.New(ioutil.Discard, "", 0)
+	router := mux.NewRouter()
+	router.Handle("/api/beers/{id}", NewDocumentHandler(typeBeer, beerIndexWriter, breweryIndexWriter, nil, logger))
+	router.Handle("/api/breweries/{id}", NewDocumentHandler(typeBrewery, beerIndexWriter, breweryIndexWriter, nil, logger))
+	put := func(path, body string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", path, strings.NewReader(body)))
+		if w.Code != 200 {
+			t.Fatalf("expected code 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	// the German analyzer stems the name to brauerei, the English one doesn't
+	countStemmed := func() uint64 {
+		reader, err := beerIndexWriter.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		dmi, err := reader.Search(context.Background(),
+			bluge.NewTopNSearch(10, bluge.NewTermQuery("brauerei").SetField("name")).WithStandardAggregations())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dmi.Aggregations().Count()
+	}
+
+	put("/api/breweries/test_brewing", `{"type":"brewery","name":"Test Brewing","country":"United States"}`)
+	put("/api/beers/test_brewing-brauereien", `{"type":"beer","name":"Brauereien","brewery_id":"test_brewing"}`)
+	if count := countStemmed(); count != 0 {
+		t.Errorf("expected the beer to be analyzed in English, got %d German matches", count)
+	}
+
+	put("/api/breweries/test_brewing", `{"type":"brewery","name":"Test Brewing","country":"Germany"}`)
+	if count := countStemmed(); count != 1 {
+		t.Errorf("expected the beer to be reanalyzed in German, got %d German matches", count)
+	}
+}
@@ -0,0 +1,159 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDRegexp limits the request IDs accepted from clients to what is
+// safe to log and echo back
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestLogKey struct{}
+
+// requestLog collects the fields handlers add to the log entry of the
+// request
+type requestLog struct {
+	id     string
+	m      sync.Mutex
+	fields LogFields
+}
+
+// assignRequestIDs gives each request an ID, the one the client sent in
+// X-Request-ID if any, which is returned in the response and identifies
+// the log entries of the request
+func assignRequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		rl := &requestLog{id: id, fields: make(LogFields)}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestLogKey{}, rl)))
+	})
+}
+
+func newRequestID() string {
+	var id [8]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(id[:])
+}
+
+func requestLogFor(req *http.Request) *requestLog {
+	rl, _ := req.Context().Value(requestLogKey{}).(*requestLog)
+	return rl
+}
+
+// RequestID returns the ID assigned to the request, if any
+func RequestID(req *http.Request) string {
+	if rl := requestLogFor(req); rl != nil {
+		return rl.id
+	}
+	return ""
+}
+
+// addLogFields adds the fields to the log entry of the request
+func addLogFields(req *http.Request, fields LogFields) {
+	rl := requestLogFor(req)
+	if rl == nil {
+		return
+	}
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	for k, v := range fields {
+		rl.fields[k] = v
+	}
+}
+
+// logRequests logs an entry for each request once it has been answered,
+// with the fields added by its handler.  Server errors are logged at error
+// level and client errors at warn level.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		startTime := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, req)
+
+		fields := LogFields{
+			"request_id":  RequestID(req),
+			"method":      req.Method,
+			"path":        req.URL.Path,
+			"endpoint":    routeTemplate(req),
+			"status":      sw.code,
+			"duration_ms": durationMillis(time.Since(startTime)),
+			"remote_addr": req.RemoteAddr,
+		}
+		if rl := requestLogFor(req); rl != nil {
+			rl.m.Lock()
+			for k, v := range rl.fields {
+				fields[k] = v
+			}
+			rl.m.Unlock()
+		}
+		level := LevelInfo
+		switch {
+		case sw.code >= 500:
+			level = LevelError
+		case sw.code >= 400:
+			level = LevelWarn
+		}
+		appLog.Log(level, "request", fields)
+	})
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// logSearch adds the search to the log entry of the request, and to the
+// query log
+func logSearch(req *http.Request, r *SearchRequest, response *SearchResponse, took time.Duration) {
+	fields := LogFields{
+		"query":   r.Query,
+		"filters": r.Filters,
+		"page":    r.Page,
+		"hits":    response.Total,
+	}
+	addLogFields(req, fields)
+
+	if queryLog == nil {
+		return
+	}
+	fields["request_id"] = RequestID(req)
+	fields["size"] = r.Size
+	fields["sort"] = r.Sort
+	fields["fuzzy"] = response.Fuzzy
+	fields["duration_ms"] = durationMillis(took)
+	if len(r.QueryDSL) > 0 {
+		fields["query_dsl"] = r.QueryDSL
+	}
+	if response.DidYouMean != "" {
+		fields["did_you_mean"] = response.DidYouMean
+	}
+	queryLog.Info("search", fields)
+}
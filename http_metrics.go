@@ -0,0 +1,167 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/gorilla/mux"
+)
+
+const beerIndexName = "beers"
+const breweryIndexName = "breweries"
+
+// statusWriter remembers the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// instrumentRequests counts the requests to each route of the router, and
+// how long they take, by status code
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		startTime := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, req)
+
+		endpoint := routeTemplate(req)
+		code := strconv.Itoa(sw.code)
+		httpRequests.Inc(endpoint, code)
+		httpRequestDuration.Observe(time.Since(startTime).Seconds(), endpoint, code)
+	})
+}
+
+// routeTemplate names the route of the request by its path template, so
+// that documents do not each have their own endpoint
+func routeTemplate(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return req.URL.Path
+}
+
+// observeSearch records the hits of a search
+func observeSearch(total uint64) {
+	searches.Inc()
+	if total == 0 {
+		zeroResultSearches.Inc()
+	}
+	searchHitCounts.Observe(float64(total))
+}
+
+// observeSearchStart records the searches of the index, as the search start
+// function of its config
+func observeSearchStart(index string) func(size uint64) error {
+	return func(size uint64) error {
+		indexSearches.Inc(index)
+		indexSearchMemory.Add(float64(size), index)
+		return nil
+	}
+}
+
+// MetricsHandler exposes the metrics in the Prometheus text format, with
+// the stats of the indexes as of the request
+type MetricsHandler struct {
+	beerIndexWriter    *bluge.Writer
+	breweryIndexWriter *bluge.Writer
+	beerIndexPath      string
+	breweryIndexPath   string
+	logger             *log.Logger
+}
+
+func NewMetricsHandler(beerIndexWriter, breweryIndexWriter *bluge.Writer, beerIndexPath, breweryIndexPath string,
+	logger *log.Logger) *MetricsHandler {
+	return &MetricsHandler{
+		beerIndexWriter:    beerIndexWriter,
+		breweryIndexWriter: breweryIndexWriter,
+		beerIndexPath:      beerIndexPath,
+		breweryIndexPath:   breweryIndexPath,
+		logger:             logger,
+	}
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := observeIndex(beerIndexName, h.beerIndexWriter, h.beerIndexPath)
+	if err == nil {
+		err = observeIndex(breweryIndexName, h.breweryIndexWriter, h.breweryIndexPath)
+	}
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error reading index stats: %v", err), 500, h.logger)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err = metrics.Expose(w)
+	if err != nil {
+		h.logger.Printf("error writing metrics: %v", err)
+	}
+}
+
+// observeIndex records the documents of the index and its files on disk
+func observeIndex(index string, indexWriter *bluge.Writer, path string) error {
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = indexReader.Close()
+	}()
+	count, err := indexReader.Count()
+	if err != nil {
+		return err
+	}
+	indexDocuments.Set(float64(count), index)
+
+	var files, segments int
+	var size int64
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// files are removed as segments are merged
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files++
+		size += info.Size()
+		if strings.HasSuffix(path, ".seg") {
+			segments++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	indexDiskFiles.Set(float64(files), index)
+	indexDiskBytes.Set(float64(size), index)
+	indexSegments.Set(float64(segments), index)
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/blugelabs/bluge"
@@ -28,103 +29,317 @@ import (
 )
 
 const resultsPerPage = 10
+const maxResultsPerPage = 1000
 const roundDurationTo = 500 * time.Microsecond
 const styleAggregation = "style-facet"
 const abvAggregation = "abv"
+const ibuAggregation = "ibu"
+const srmAggregation = "srm"
 const typeAggregation = "type"
 const updatedAggregation = "updated"
+const categoryAggregation = "category-facet"
+const countryAggregation = "country-facet"
+const stateAggregation = "state-facet"
+const cityAggregation = "city-facet"
+const breweryAggregation = "brewery_id"
+const beerCountAggregation = "beer_count"
+const mainStyleAggregation = "main-style-facet"
+const mainCategoryAggregation = "main-category-facet"
+const locationField = "location"
 
 // SearchHandler can handle search requests sent over HTTP
 type SearchHandler struct {
 	beerIndexWriter    *bluge.Writer
 	breweryIndexWriter *bluge.Writer
+	analytics          *AnalyticsStore
 	logger             *log.Logger
 }
 
-func NewSearchHandler(beerIndexWriter, breweryIndexWriter *bluge.Writer, logger *log.Logger) *SearchHandler {
+// NewSearchHandler searches the indexes, recording the searches in the
+// analytics store unless it is nil
+func NewSearchHandler(beerIndexWriter, breweryIndexWriter *bluge.Writer, analytics *AnalyticsStore,
+	logger *log.Logger) *SearchHandler {
 	return &SearchHandler{
 		beerIndexWriter:    beerIndexWriter,
 		breweryIndexWriter: breweryIndexWriter,
+		analytics:          analytics,
 		logger:             logger,
 	}
 }
 
 func (h *SearchHandler) Readers() (beerReader, breweryReader *bluge.Reader, err error) {
-	beerReader, err = h.beerIndexWriter.Reader()
+	return openReaders(h.beerIndexWriter, h.breweryIndexWriter)
+}
+
+func openReaders(beerIndexWriter, breweryIndexWriter *bluge.Writer) (beerReader, breweryReader *bluge.Reader, err error) {
+	beerReader, err = beerIndexWriter.Reader()
 	if err != nil {
 		return nil, nil, err
 	}
-	breweryReader, err = h.breweryIndexWriter.Reader()
+	breweryReader, err = breweryIndexWriter.Reader()
 	if err != nil {
+		_ = beerReader.Close()
 		return nil, nil, err
 	}
 	return beerReader, breweryReader, nil
 }
 
 func (h *SearchHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	requestBody, err := ioutil.ReadAll(req.Body)
+	startTime := time.Now()
+	searchRequest, err := readSearchRequest(req)
 	if err != nil {
-		showError(w, req, fmt.Sprintf("error reading request body: %v", err), 400, h.logger)
+		showError(w, req, err.Error(), 400, h.logger)
 		return
 	}
 
-	var searchRequest SearchRequest
-	err = json.Unmarshal(requestBody, &searchRequest)
+	beerReader, breweryReader, err := h.Readers()
 	if err != nil {
-		showError(w, req, fmt.Sprintf("error parsing request: %v", err), 400, h.logger)
+		showError(w, req, err.Error(), 400, h.logger)
 		return
 	}
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
 
-	blugeRequest, err := searchRequest.BlugeRequest()
+	err = searchRequest.JoinBreweries(breweryReader)
 	if err != nil {
 		showError(w, req, err.Error(), 400, h.logger)
 		return
 	}
 
-	beerReader, breweryReader, err := h.Readers()
+	blugeRequest, err := searchRequest.BlugeRequest()
 	if err != nil {
 		showError(w, req, err.Error(), 400, h.logger)
 		return
 	}
 
-	blugeResponse, err := bluge.MultiSearch(context.Background(), blugeRequest, beerReader, breweryReader)
+	results, err := searchHits(blugeRequest, searchRequest, beerReader, breweryReader)
 	if err != nil {
 		showError(w, req, fmt.Sprintf("error executing query: %v", err), 500, h.logger)
 		return
 	}
+	if searchRequest.FuzzyEnabled() && results.aggs.Count() < fuzzyMinHits && strings.TrimSpace(searchRequest.Query) != "" {
+		results, err = searchFuzzy(searchRequest, results, beerReader, breweryReader)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error executing fuzzy query: %v", err), 500, h.logger)
+			return
+		}
+	}
+	facetRequests, err := searchRequest.FacetRequests()
+	if err != nil {
+		showError(w, req, err.Error(), 400, h.logger)
+		return
+	}
 
 	searchResponse := NewSearchResponse(searchRequest.Query)
-
-	next, err := blugeResponse.Next()
-	for err == nil && next != nil {
-		var docID string
-		var doc Indexable
-		docID, doc, err = matchToIndexable(next)
+	searchResponse.Hits = results.hits
+	searchResponse.Fuzzy = searchRequest.fuzzy
+	searchResponse.DidYouMean = searchRequest.DidYouMean()
+	if searchRequest.IncludeBrewery {
+		err = embedBreweries(breweryReader, searchResponse.Hits, searchRequest)
 		if err != nil {
-			showError(w, req, fmt.Sprintf("error restoring document from match: %v", err), 500, h.logger)
+			showError(w, req, fmt.Sprintf("error loading breweries: %v", err), 500, h.logger)
 			return
 		}
+	}
 
-		searchResponse.Hits = append(searchResponse.Hits, &DocumentMatch{
-			ID:       docID,
-			Document: doc,
-			Score:    next.Score,
-			Expl:     next.Explanation,
-		})
+	if searchRequest.Group != nil {
+		searchResponse.Groups, err = searchGroups(searchRequest, beerReader, breweryReader)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error grouping results: %v", err), 500, h.logger)
+			return
+		}
+	}
 
-		next, err = blugeResponse.Next()
+	searchResponse.AddAggregations(results.aggs, searchRequest)
+	for _, aggregationRequest := range searchRequest.AggregationRequests() {
+		facetRequest, ok := facetRequests[aggregationRequest.Name]
+		if !ok {
+			continue
+		}
+		var facetAggs *search.Bucket
+		facetAggs, err = facetAggregations(facetRequest, beerReader, breweryReader)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error executing facet query: %v", err), 500, h.logger)
+			return
+		}
+		searchResponse.SetAggregation(facetAggs, aggregationRequest, searchRequest.Filters)
 	}
+	searchResponse.AddPaging(results.aggs, searchRequest.Page, searchRequest.Size,
+		searchRequest.Cursor != "")
+	observeSearch(searchResponse.Total)
+	logSearch(req, searchRequest, searchResponse, time.Since(startTime))
+	h.recordSearch(searchRequest, searchResponse.Total)
+	err = nameBreweries(breweryReader, searchResponse.Aggregations)
 	if err != nil {
-		showError(w, req, fmt.Sprintf("error executing query: %v", err), 500, h.logger)
+		showError(w, req, fmt.Sprintf("error loading brewery names: %v", err), 500, h.logger)
 		return
 	}
-
-	searchResponse.AddAggregations(blugeResponse.Aggregations(), searchRequest.Filters)
-	searchResponse.AddPaging(blugeResponse.Aggregations(), searchRequest.Page)
+	if results.more {
+		searchResponse.Cursor, err = EncodeCursor(searchRequest.Sort, results.lastSortValue)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error encoding cursor: %v", err), 500, h.logger)
+			return
+		}
+	}
 
 	mustEncode(w, searchResponse)
 }
 
+// recordSearch records the search in the analytics store, if there is one
+func (h *SearchHandler) recordSearch(r *SearchRequest, total uint64) {
+	if h.analytics == nil {
+		return
+	}
+	err := h.analytics.RecordSearch(r, total)
+	if err != nil {
+		h.logger.Printf("error recording search: %v", err)
+	}
+}
+
+// readSearchRequest reads the search request from the URL parameters of
+// GET requests, or the JSON body of POST requests
+func readSearchRequest(req *http.Request) (*SearchRequest, error) {
+	if req.Method == http.MethodGet {
+		return ParseSearchRequest(req.URL.Query())
+	}
+	requestBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %v", err)
+	}
+	searchRequest := &SearchRequest{}
+	err = json.Unmarshal(requestBody, searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing request: %v", err)
+	}
+	return searchRequest, nil
+}
+
+// searchResults are the hits of a search, with its aggregations
+type searchResults struct {
+	hits          []*DocumentMatch
+	lastSortValue [][]byte
+	aggs          *search.Bucket
+	// more is set when there are hits beyond the page
+	more bool
+}
+
+// searchHits runs the search, restoring the documents of the hits on the
+// page, the search may find one hit more to tell whether there are more
+func searchHits(blugeRequest bluge.SearchRequest, r *SearchRequest, readers ...*bluge.Reader) (*searchResults, error) {
+	dmi, err := bluge.MultiSearch(context.Background(), blugeRequest, readers...)
+	if err != nil {
+		return nil, err
+	}
+	rv := &searchResults{}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		if len(rv.hits) == r.Size {
+			rv.more = true
+			break
+		}
+		var hit *DocumentMatch
+		hit, err = newDocumentMatch(next, r)
+		if err != nil {
+			return nil, fmt.Errorf("error restoring document from match: %v", err)
+		}
+		rv.hits = append(rv.hits, hit)
+		rv.lastSortValue = copySortValue(next.SortValue)
+
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	rv.aggs = dmi.Aggregations()
+	return rv, nil
+}
+
+// nameBreweries displays the values of brewery facets, which are brewery
+// IDs, as the names of the breweries
+func nameBreweries(breweryReader *bluge.Reader, aggs map[string]*Aggregation) error {
+	var values []*AggregationValue
+	var collect func(aggs map[string]*Aggregation)
+	collect = func(aggs map[string]*Aggregation) {
+		for name, agg := range aggs {
+			for _, value := range agg.Values {
+				if name == breweryAggregation {
+					values = append(values, value)
+				}
+				collect(value.Aggregations)
+			}
+		}
+	}
+	collect(aggs)
+	if len(values) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		ids = append(ids, value.FilterName)
+	}
+	breweries, err := loadBreweries(breweryReader, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		if brewery, ok := breweries[value.FilterName]; ok && brewery.Name != "" {
+			value.DisplayName = brewery.Name
+		}
+	}
+	return nil
+}
+
+// facetAggregations runs a request which only aggregates, and returns the
+// aggregations once every match has been visited
+func facetAggregations(facetRequest bluge.SearchRequest, readers ...*bluge.Reader) (*search.Bucket, error) {
+	dmi, err := bluge.MultiSearch(context.Background(), facetRequest, readers...)
+	if err != nil {
+		return nil, err
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dmi.Aggregations(), nil
+}
+
+// newDocumentMatch restores the document of the match as a hit
+func newDocumentMatch(next *search.DocumentMatch, r *SearchRequest) (*DocumentMatch, error) {
+	docID, doc, err := matchToIndexable(next)
+	if err != nil {
+		return nil, err
+	}
+	hit := &DocumentMatch{
+		ID:       docID,
+		Document: doc,
+		Score:    next.Score,
+		Expl:     next.Explanation,
+	}
+	if brewery, ok := doc.(*Brewery); ok && r.Geo != nil && r.Geo.Center != nil {
+		distance := r.Geo.DistanceTo(brewery.Geo)
+		hit.Distance = &distance
+	}
+	if r.Highlight {
+		hit.Fragments = highlightFragments(doc, next.Locations)
+	}
+	return hit, nil
+}
+
+func copySortValue(sortValue [][]byte) [][]byte {
+	rv := make([][]byte, len(sortValue))
+	for i, value := range sortValue {
+		rv[i] = append([]byte(nil), value...)
+	}
+	return rv
+}
+
 func matchToIndexable(d *search.DocumentMatch) (string, Indexable, error) {
 	var _id string
 	var _type string
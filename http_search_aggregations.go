@@ -0,0 +1,461 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/aggregations"
+)
+
+// facetNames are the filters which are also aggregated as facets
+var facetNames = []string{typeAggregation, styleAggregation, updatedAggregation, abvAggregation,
+	ibuAggregation, srmAggregation, categoryAggregation, countryAggregation, stateAggregation, cityAggregation, breweryAggregation,
+	beerCountAggregation, mainStyleAggregation, mainCategoryAggregation}
+
+// defaultFacetNames are the facets returned when a request does not list any
+var defaultFacetNames = []string{typeAggregation, styleAggregation, updatedAggregation, abvAggregation}
+
+// termsFacetFields maps the facets which count the distinct values of a
+// keyword field to that field
+var termsFacetFields = map[string]string{
+	typeAggregation:     "_type",
+	styleAggregation:    "style-facet",
+	categoryAggregation: "category-facet",
+	countryAggregation:  "country-facet",
+	stateAggregation:    "state-facet",
+	cityAggregation:     "city-facet",
+	breweryAggregation:  "brewery_id",
+
+	mainStyleAggregation:    mainStyleAggregation,
+	mainCategoryAggregation: mainCategoryAggregation,
+}
+
+// defaultFacetSizes are the number of values returned for facets which
+// count terms, when the request does not say
+var defaultFacetSizes = map[string]int{
+	typeAggregation: 2,
+}
+
+const defaultFacetSize = 5
+
+const maxFacetSize = 100
+
+// metricFields are the numeric fields metrics may be calculated on
+var metricFields = map[string]bool{
+	"abv": true,
+	"ibu": true,
+	"srm": true,
+
+	beerCountAggregation: true,
+}
+
+// metricFieldNames lists the metric fields in order, for error messages
+func metricFieldNames() string {
+	names := make([]string, 0, len(metricFields))
+	for name := range metricFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+const metricMin = "min"
+const metricMax = "max"
+const metricAvg = "avg"
+const metricSum = "sum"
+const metricQuantiles = "quantiles"
+
+var defaultQuantiles = []float64{0.5}
+
+// reservedAggregationNames are calculated for every bucket by bluge
+var reservedAggregationNames = map[string]bool{
+	"count":     true,
+	"duration":  true,
+	"max_score": true,
+}
+
+// AggregationRequest asks for the values of a facet to be counted, along
+// with metrics calculated over the documents having each value, and
+// further facets nested beneath each value.  The ibu, srm and beer_count
+// facets, and the abv facet when asked for a histogram, count values in
+// buckets of the interval, or about the number of buckets requested when
+// the interval is left out.  The updated facet counts dates by year,
+// quarter, month or week when given a calendar interval.
+type AggregationRequest struct {
+	Name             string                `json:"name"`
+	Size             int                   `json:"size"`
+	Histogram        bool                  `json:"histogram"`
+	Interval         float64               `json:"interval"`
+	Buckets          int                   `json:"buckets"`
+	CalendarInterval string                `json:"calendar_interval"`
+	Metrics          []*MetricRequest      `json:"metrics"`
+	Aggregations     []*AggregationRequest `json:"aggregations"`
+}
+
+// defaultAggregationRequests are the facets returned when a search
+// request does not list any
+func defaultAggregationRequests() []*AggregationRequest {
+	rv := make([]*AggregationRequest, 0, len(defaultFacetNames))
+	for _, name := range defaultFacetNames {
+		rv = append(rv, &AggregationRequest{Name: name})
+	}
+	return rv
+}
+
+func (a *AggregationRequest) Validate() error {
+	if !isFacet(a.Name) {
+		return fmt.Errorf("unknown aggregation '%s', expected one of: %s", a.Name, strings.Join(facetNames, ", "))
+	}
+	if a.Size < 0 || a.Size > maxFacetSize {
+		return fmt.Errorf("aggregation '%s' size must be between 0 and %d, got %d", a.Name, maxFacetSize, a.Size)
+	}
+	if (a.Histogram || a.Interval != 0 || a.Buckets != 0) && !metricFields[a.Name] {
+		return fmt.Errorf("aggregation '%s' is not numeric, histograms are only available for: %s", a.Name,
+			metricFieldNames())
+	}
+	if a.CalendarInterval != "" {
+		if a.Name != updatedAggregation {
+			return fmt.Errorf("aggregation '%s' is not a date, calendar intervals are only available for updated", a.Name)
+		}
+		if !isCalendarInterval(a.CalendarInterval) {
+			return fmt.Errorf("aggregation '%s' calendar interval must be one of: %s, got '%s'",
+				a.Name, strings.Join(calendarIntervals, ", "), a.CalendarInterval)
+		}
+	}
+	if a.Interval < 0 {
+		return fmt.Errorf("aggregation '%s' interval must be positive, got %g", a.Name, a.Interval)
+	}
+	if a.Buckets < 0 || a.Buckets > maxFacetSize {
+		return fmt.Errorf("aggregation '%s' buckets must be between 0 and %d, got %d", a.Name, maxFacetSize, a.Buckets)
+	}
+	err := validateMetrics(a.Metrics, a.Aggregations)
+	if err != nil {
+		return fmt.Errorf("aggregation '%s': %v", a.Name, err)
+	}
+	for _, sub := range a.Aggregations {
+		err = sub.Validate()
+		if err != nil {
+			return fmt.Errorf("aggregation '%s': %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateMetrics checks the metrics and aggregations calculated together
+// for one bucket, their names must all be distinct
+func validateMetrics(metrics []*MetricRequest, aggs []*AggregationRequest) error {
+	names := make(map[string]bool)
+	for _, agg := range aggs {
+		if names[agg.Name] {
+			return fmt.Errorf("duplicate aggregation '%s'", agg.Name)
+		}
+		names[agg.Name] = true
+	}
+	for _, metric := range metrics {
+		err := metric.Validate()
+		if err != nil {
+			return err
+		}
+		key := metric.Key()
+		if names[key] || reservedAggregationNames[key] {
+			return fmt.Errorf("duplicate or reserved metric name '%s'", key)
+		}
+		names[key] = true
+	}
+	return nil
+}
+
+func isCalendarInterval(interval string) bool {
+	for _, calendarInterval := range calendarIntervals {
+		if interval == calendarInterval {
+			return true
+		}
+	}
+	return false
+}
+
+func isFacet(name string) bool {
+	for _, facetName := range facetNames {
+		if name == facetName {
+			return true
+		}
+	}
+	return false
+}
+
+// isHistogram reports whether the facet counts values in buckets of equal
+// width, rather than named ranges or terms
+func (a *AggregationRequest) isHistogram() bool {
+	switch a.Name {
+	case ibuAggregation, srmAggregation, beerCountAggregation:
+		return true
+	case abvAggregation:
+		return a.Histogram || a.Interval > 0 || a.Buckets > 0
+	}
+	return false
+}
+
+func (a *AggregationRequest) size() int {
+	if a.Size > 0 {
+		return a.Size
+	}
+	if size, ok := defaultFacetSizes[a.Name]; ok {
+		return size
+	}
+	return defaultFacetSize
+}
+
+// Aggregation builds the bluge aggregation counting the values of the
+// facet, with the requested metrics and nested facets
+func (a *AggregationRequest) Aggregation() search.Aggregation {
+	if a.isHistogram() {
+		histogramAgg := newHistogramAggregation(distinctField(a.Name), a.Interval, a.Buckets)
+		return a.addNested(histogramAgg, histogramAgg.AddAggregation)
+	}
+	if a.Name == updatedAggregation && a.CalendarInterval != "" {
+		dateHistogramAgg := newDateHistogramAggregation(distinctField(a.Name), a.CalendarInterval)
+		return a.addNested(dateHistogramAgg, dateHistogramAgg.AddAggregation)
+	}
+
+	var rv search.Aggregation
+	var addAggregation func(name string, agg search.Aggregation)
+	switch a.Name {
+	case typeAggregation, styleAggregation, categoryAggregation, countryAggregation, stateAggregation,
+		cityAggregation, breweryAggregation, mainStyleAggregation, mainCategoryAggregation:
+		termsAgg := aggregations.NewTermsAggregation(aggregations.FilterText(distinctField(termsFacetFields[a.Name]),
+			func(bytes []byte) bool {
+				return len(bytes) > 0
+			}), a.size())
+		rv, addAggregation = termsAgg, termsAgg.AddAggregation
+	case updatedAggregation:
+		updatedAgg := aggregations.DateRanges(distinctField("updated"))
+		for k, v := range updatedRanges {
+			updatedAgg.AddRange(aggregations.NewNamedDateRange(k, v.Start, v.End))
+		}
+		rv = updatedAgg
+		addAggregation = func(name string, agg search.Aggregation) {
+			updatedAgg.AddAggregation(name, agg)
+		}
+	case abvAggregation:
+		abvAgg := aggregations.Ranges(distinctField("abv"))
+		for k, v := range abvRanges {
+			abvAgg.AddRange(aggregations.NamedRange(k, v.Low, v.High))
+		}
+		rv = abvAgg
+		addAggregation = func(name string, agg search.Aggregation) {
+			abvAgg.AddAggregation(name, agg)
+		}
+	default:
+		return nil
+	}
+
+	return a.addNested(rv, addAggregation)
+}
+
+// addNested adds the requested metrics and nested facets to the facet
+// aggregation, using the function adding aggregations to its buckets
+func (a *AggregationRequest) addNested(rv search.Aggregation,
+	addAggregation func(name string, agg search.Aggregation)) search.Aggregation {
+	nested := &nestedFieldsAggregation{Aggregation: rv}
+	add := func(name string, agg search.Aggregation) {
+		addAggregation(name, agg)
+		nested.nested = append(nested.nested, agg)
+	}
+	for _, metric := range a.Metrics {
+		add(metric.Key(), metric.Aggregation())
+	}
+	for _, sub := range a.Aggregations {
+		add(sub.Name, sub.Aggregation())
+	}
+	return nested
+}
+
+// nestedFieldsAggregation also asks for the fields of the nested
+// aggregations to be loaded, bluge range aggregations only ask for the
+// field being ranged over
+type nestedFieldsAggregation struct {
+	search.Aggregation
+	nested []search.Aggregation
+}
+
+func (n *nestedFieldsAggregation) Fields() []string {
+	rv := n.Aggregation.Fields()
+	for _, agg := range n.nested {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+// MetricRequest asks for the min, max, avg, sum or quantiles of a numeric
+// field, by default it is named after its type and field, like avg_abv
+type MetricRequest struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Field     string    `json:"field"`
+	Quantiles []float64 `json:"quantiles"`
+}
+
+func (m *MetricRequest) Key() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.Type + "_" + m.Field
+}
+
+func (m *MetricRequest) Validate() error {
+	if !metricFields[m.Field] {
+		return fmt.Errorf("unable to calculate metric on '%s', expected one of: %s", m.Field, metricFieldNames())
+	}
+	switch m.Type {
+	case metricMin, metricMax, metricAvg, metricSum:
+		if len(m.Quantiles) > 0 {
+			return fmt.Errorf("metric '%s' does not take quantiles", m.Type)
+		}
+	case metricQuantiles:
+		for _, q := range m.Quantiles {
+			if q < 0 || q > 1 {
+				return fmt.Errorf("quantile must be between 0 and 1, got %g", q)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown metric '%s', expected one of: min, max, avg, sum, quantiles", m.Type)
+	}
+	return nil
+}
+
+func (m *MetricRequest) Aggregation() search.Aggregation {
+	field := distinctField(m.Field)
+	switch m.Type {
+	case metricMin:
+		return aggregations.Min(field)
+	case metricMax:
+		return aggregations.Max(field)
+	case metricAvg:
+		return aggregations.Avg(field)
+	case metricSum:
+		return aggregations.Sum(field)
+	case metricQuantiles:
+		return aggregations.Quantiles(field)
+	}
+	return nil
+}
+
+func (m *MetricRequest) quantiles() []float64 {
+	if len(m.Quantiles) > 0 {
+		return m.Quantiles
+	}
+	return defaultQuantiles
+}
+
+// Metric is the value of a metric, or its quantiles keyed by the quantile
+// requested, values are omitted when there was nothing to calculate them
+// from
+type Metric struct {
+	Value     *float64           `json:"value,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+func buildMetrics(bucket *search.Bucket, requests []*MetricRequest) map[string]*Metric {
+	if len(requests) == 0 {
+		return nil
+	}
+	rv := make(map[string]*Metric, len(requests))
+	for _, request := range requests {
+		metric := &Metric{}
+		if request.Type == metricQuantiles {
+			if calc, ok := bucket.Aggregation(request.Key()).(*aggregations.QuantilesCalculator); ok {
+				metric.Quantiles = make(map[string]float64)
+				for _, q := range request.quantiles() {
+					val, err := calc.Quantile(q)
+					if err == nil && isFinite(val) {
+						metric.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = val
+					}
+				}
+			}
+		} else if val := bucket.Metric(request.Key()); isFinite(val) {
+			metric.Value = &val
+		}
+		rv[request.Key()] = metric
+	}
+	return rv
+}
+
+// isFinite reports whether the value can be encoded as JSON, min and max
+// start at infinity and averages of nothing are NaN
+func isFinite(val float64) bool {
+	return !math.IsInf(val, 0) && !math.IsNaN(val)
+}
+
+// distinctField reads the values of a single valued field for aggregations.
+// bluge loads the doc values of a field again for the sort and for every
+// aggregation using it, so the value is repeated once for each, and would
+// be counted as many times.
+type distinctField string
+
+func (f distinctField) Fields() []string {
+	return []string{string(f)}
+}
+
+func (f distinctField) Values(match *search.DocumentMatch) [][]byte {
+	values := search.Field(string(f)).Values(match)
+	rv := values[:0:0]
+OUTER:
+	for _, value := range values {
+		for _, seen := range rv {
+			if string(seen) == string(value) {
+				continue OUTER
+			}
+		}
+		rv = append(rv, value)
+	}
+	return rv
+}
+
+func (f distinctField) Numbers(match *search.DocumentMatch) []float64 {
+	numbers := search.Field(string(f)).Numbers(match)
+	rv := numbers[:0]
+OUTER:
+	for _, number := range numbers {
+		for _, seen := range rv {
+			if seen == number {
+				continue OUTER
+			}
+		}
+		rv = append(rv, number)
+	}
+	return rv
+}
+
+func (f distinctField) Dates(match *search.DocumentMatch) []time.Time {
+	dates := search.Field(string(f)).Dates(match)
+	rv := dates[:0]
+OUTER:
+	for _, date := range dates {
+		for _, seen := range rv {
+			if seen.Equal(date) {
+				continue OUTER
+			}
+		}
+		rv = append(rv, date)
+	}
+	return rv
+}
@@ -0,0 +1,241 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestSearchRequestValidateAggregations(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *SearchRequest
+		wantErr bool
+	}{
+		{
+			name: "default",
+			in:   &SearchRequest{},
+		},
+		{
+			name: "nested with metrics",
+			in: &SearchRequest{
+				Aggregations: []*AggregationRequest{
+					{
+						Name: "style-facet",
+						Size: 20,
+						Metrics: []*MetricRequest{
+							{Type: "quantiles", Field: "abv", Quantiles: []float64{0.5, 0.99}},
+							{Type: "avg", Field: "ibu"},
+						},
+						Aggregations: []*AggregationRequest{{Name: "abv"}},
+					},
+				},
+				Metrics: []*MetricRequest{{Type: "max", Field: "srm"}},
+			},
+		},
+		{
+			name: "keyword facets",
+			in: &SearchRequest{
+				Aggregations: []*AggregationRequest{
+					{Name: "country-facet", Aggregations: []*AggregationRequest{{Name: "state-facet"}}},
+					{Name: "category-facet"},
+					{Name: "brewery_id", Size: 20},
+				},
+			},
+		},
+		{
+			name: "histograms",
+			in: &SearchRequest{
+				Aggregations: []*AggregationRequest{
+					{Name: "abv", Histogram: true},
+					{Name: "ibu", Interval: 10},
+					{Name: "srm", Buckets: 20},
+				},
+			},
+		},
+		{
+			name: "date histogram",
+			in:   &SearchRequest{Aggregations: []*AggregationRequest{{Name: "updated", CalendarInterval: "month"}}},
+		},
+		{
+			name:    "calendar interval on numbers",
+			in:      &SearchRequest{Aggregations: []*AggregationRequest{{Name: "abv", CalendarInterval: "year"}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown calendar interval",
+			in:      &SearchRequest{Aggregations: []*AggregationRequest{{Name: "updated", CalendarInterval: "decade"}}},
+			wantErr: true,
+		},
+		{
+			name:    "histogram of terms",
+			in:      &SearchRequest{Aggregations: []*AggregationRequest{{Name: "style-facet", Interval: 5}}},
+			wantErr: true,
+		},
+		{
+			name:    "negative interval",
+			in:      &SearchRequest{Aggregations: []*AggregationRequest{{Name: "ibu", Interval: -5}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown facet",
+			in:      &SearchRequest{Aggregations: []*AggregationRequest{{Name: "desc"}}},
+			wantErr: true,
+		},
+		{
+			name:    "nested unknown facet",
+			in:      &SearchRequest{Aggregations: []*AggregationRequest{{Name: "type", Aggregations: []*AggregationRequest{{Name: "desc"}}}}},
+			wantErr: true,
+		},
+		{
+			name:    "too large",
+			in:      &SearchRequest{Aggregations: []*AggregationRequest{{Name: "style-facet", Size: 1000}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown metric",
+			in:      &SearchRequest{Metrics: []*MetricRequest{{Type: "median", Field: "abv"}}},
+			wantErr: true,
+		},
+		{
+			name:    "metric on text",
+			in:      &SearchRequest{Metrics: []*MetricRequest{{Type: "max", Field: "name"}}},
+			wantErr: true,
+		},
+		{
+			name:    "quantile out of range",
+			in:      &SearchRequest{Metrics: []*MetricRequest{{Type: "quantiles", Field: "abv", Quantiles: []float64{50}}}},
+			wantErr: true,
+		},
+		{
+			name:    "metric named like facet",
+			in:      &SearchRequest{Metrics: []*MetricRequest{{Name: "abv", Type: "max", Field: "abv"}}},
+			wantErr: true,
+		},
+		{
+			name:    "reserved metric name",
+			in:      &SearchRequest{Metrics: []*MetricRequest{{Name: "count", Type: "sum", Field: "abv"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.in.validateAggregations()
+			if test.wantErr && err == nil {
+				t.Errorf("expected error, got nil")
+			} else if !test.wantErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestMetricRequestValidateMessage(t *testing.T) {
+	err := (&MetricRequest{Type: "max", Field: "name"}).Validate()
+	expect := "unable to calculate metric on 'name', expected one of: abv, beer_count, ibu, srm"
+	if err == nil || err.Error() != expect {
+		t.Errorf("expected error %q, got: %v", expect, err)
+	}
+}
+
+// aggregating a field also used by the sort, or by another aggregation,
+// must not count its values more than once
+func TestAggregationsSharingFields(t *testing.T) {
+	indexWriter, err := bluge.OpenWriter(bluge.InMemoryOnlyConfig())
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	files, err := ioutil.ReadDir("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := bluge.NewBatch()
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "yuengling_son_brewing-") {
+			continue
+		}
+		_, doc, err := parseAndBuildDoc("data", file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch.Update(doc.ID(), doc)
+	}
+	err = indexWriter.Batch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = indexReader.Close()
+	}()
+
+	searchRequest := &SearchRequest{
+		Query: "type:beer",
+		Sort:  []string{"-abv"},
+		Aggregations: []*AggregationRequest{
+			{
+				Name:    "abv",
+				Metrics: []*MetricRequest{{Type: "sum", Field: "abv"}},
+			},
+		},
+		Metrics: []*MetricRequest{{Type: "sum", Field: "abv"}},
+	}
+	blugeRequest, err := searchRequest.BlugeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmi, err := indexReader.Search(context.Background(), blugeRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	searchResponse := NewSearchResponse(searchRequest.Query)
+	searchResponse.AddAggregations(dmi.Aggregations(), searchRequest)
+
+	sum := searchResponse.Metrics["sum_abv"].Value
+	if sum == nil || math.Abs(*sum-30.5) > 0.001 {
+		t.Errorf("expected sum of abv 30.5, got %v", sum)
+	}
+	expectCounts := map[string]uint64{"low": 1, "med": 6, "high": 1}
+	for _, value := range searchResponse.Aggregations["abv"].Values {
+		if value.Count != expectCounts[value.FilterName] {
+			t.Errorf("expected %d beers with %s abv, got %d", expectCounts[value.FilterName], value.FilterName, value.Count)
+		}
+		if value.FilterName == "high" {
+			bucketSum := value.Metrics["sum_abv"].Value
+			if bucketSum == nil || math.Abs(*bucketSum-5.4) > 0.001 {
+				t.Errorf("expected sum of high abv 5.4, got %v", bucketSum)
+			}
+		}
+	}
+}
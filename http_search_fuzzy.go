@@ -0,0 +1,344 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blugelabs/bluge"
+)
+
+// spellingField indexes the words of the text of documents as written,
+// only lowercased and folded to ASCII, so that misspelled words can be
+// corrected to words of the index, rather than to stemmed terms
+const spellingField = "spelling"
+
+// fuzzyMinHits is the number of hits below which searches are retried with
+// fuzzy matching
+const fuzzyMinHits = 3
+
+// minSpellingLength is the length below which words are too short to tell
+// misspellings from other words
+const minSpellingLength = 4
+
+// spellingRatio is how many times more documents a correction must be in
+// than the word as it was spelled, when the word is in the index at all
+const spellingRatio = 10
+
+// fuzzyBoost weighs fuzzy matches below exact matches, and matches of
+// corrected words
+const fuzzyBoost = 0.5
+
+// fuzzyFields are the fields searched by words which may be misspelled
+var fuzzyFields = []string{"name", "_all"}
+
+func newSpellingField(value string) *bluge.TermField {
+	return bluge.NewTextField(spellingField, value).WithAnalyzer(analyzers["folding"])
+}
+
+// FuzzyEnabled returns whether searches finding few hits are retried with
+// fuzzy matching, which they are unless the request opts out
+func (r *SearchRequest) FuzzyEnabled() bool {
+	return r.Fuzzy == nil || *r.Fuzzy
+}
+
+// fuzzyClause parses the clause, returning nil unless it is a word
+// searched in the fields with fuzzy matching, which may be misspelled
+func fuzzyClause(raw string) *queryClause {
+	clause := parseQueryClause(raw, func(field string) bool {
+		return containsString(fuzzyFields, analyzedField(field))
+	})
+	if clause.word == "" || clause.prefix == "-" || utf8.RuneCountInString(clause.word) < minSpellingLength {
+		return nil
+	}
+	return clause
+}
+
+// maxEdits returns the number of edits a word may be misspelled by
+func maxEdits(word string) int {
+	if utf8.RuneCountInString(word) <= 5 {
+		return 1
+	}
+	return 2
+}
+
+// CorrectSpelling corrects the words of the query string which are not
+// in the index, or are in far fewer documents than a word spelled alike,
+// and has the query fuzzy match them
+func (r *SearchRequest) CorrectSpelling(readers ...*bluge.Reader) error {
+	r.corrections = make(map[string]string)
+	for _, raw := range splitQueryString(r.Query) {
+		clause := fuzzyClause(raw)
+		if clause == nil {
+			continue
+		}
+		word := analyzeText(analyzers["folding"], clause.word)
+		if strings.Contains(word, " ") {
+			continue
+		}
+		correction, err := correctSpelling(word, readers)
+		if err != nil {
+			return err
+		}
+		if correction != "" {
+			r.corrections[clause.word] = correction
+		}
+	}
+	r.fuzzy = true
+	return nil
+}
+
+// correctSpelling returns the word in the spelling dictionaries of the
+// readers closest to the word, and in the most documents of those as
+// close, or nothing if the word is spelled right
+func correctSpelling(word string, readers []*bluge.Reader) (string, error) {
+	var count uint64
+	counts := make(map[string]uint64)
+	distances := make(map[string]int)
+	for _, reader := range readers {
+		wordCount, err := termDocFreq(reader, spellingField, word)
+		if err != nil {
+			return "", err
+		}
+		count += wordCount
+		err = visitSpellings(reader, word, func(term string, termCount uint64, distance int) {
+			counts[term] += termCount
+			distances[term] = distance
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	candidates := make([]string, 0, len(counts))
+	for term := range counts {
+		candidates = append(candidates, term)
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return a < b
+	})
+	if count > 0 && counts[candidates[0]] < count*spellingRatio {
+		return "", nil
+	}
+	return candidates[0], nil
+}
+
+// visitSpellings visits the terms of the spelling dictionary of the reader
+// within the edits the word may be misspelled by.  Misspellings seldom
+// start with the wrong letter, so only terms starting with the same letter
+// are compared.
+func visitSpellings(reader *bluge.Reader, word string, visitor func(term string, count uint64, distance int)) error {
+	first, size := utf8.DecodeRuneInString(word)
+	if first == utf8.RuneError {
+		return nil
+	}
+	start := []byte(word[:size])
+	end := append([]byte(word[:size-1]), word[size-1]+1)
+	dict, err := reader.DictionaryIterator(spellingField, nil, start, end)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dict.Close()
+	}()
+
+	edits := maxEdits(word)
+	entry, err := dict.Next()
+	for err == nil && entry != nil {
+		if term := entry.Term(); term != word {
+			if distance := editDistance(word, term, edits); distance <= edits {
+				visitor(term, entry.Count(), distance)
+			}
+		}
+		entry, err = dict.Next()
+	}
+	return err
+}
+
+// editDistance returns the Levenshtein distance between the words, or
+// max+1 once it is known to be more than max
+func editDistance(a, b string, max int) int {
+	ar, br := []rune(a), []rune(b)
+	if len(ar)-len(br) > max || len(br)-len(ar) > max {
+		return max + 1
+	}
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+			rowMin = minInt(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// DidYouMean returns the query string with its misspelled words corrected,
+// or nothing when no words were corrected
+func (r *SearchRequest) DidYouMean() string {
+	if len(r.corrections) == 0 {
+		return ""
+	}
+	raws := splitQueryString(r.Query)
+	for i, raw := range raws {
+		clause := fuzzyClause(raw)
+		if clause == nil {
+			continue
+		}
+		if correction, ok := r.corrections[clause.word]; ok {
+			raws[i] = clause.format(clause.prefix, correction)
+		}
+	}
+	return strings.Join(raws, " ")
+}
+
+// fuzzyQueryString parses the query string, matching the documents it
+// matches and the documents matching its words which may be misspelled
+// fuzzily, or as corrected.  Required clauses remain required, and
+// excluded clauses excluded.
+func fuzzyQueryString(q string, corrections map[string]string) (bluge.Query, error) {
+	exact, err := parseQueryString(q)
+	if err != nil {
+		return nil, err
+	}
+
+	fuzzy := bluge.NewBooleanQuery()
+	var fuzzed, required bool
+	for _, raw := range splitQueryString(q) {
+		clause := parseQueryClause(raw, func(string) bool { return false })
+		var query bluge.Query
+		query, err = parseQueryString(raw[len(clause.prefix):])
+		if err != nil {
+			return nil, err
+		}
+		if fc := fuzzyClause(raw); fc != nil {
+			query, err = fuzzyAlternatives(fc, query, corrections)
+			if err != nil {
+				return nil, err
+			}
+			fuzzed = true
+		}
+		switch clause.prefix {
+		case "+":
+			fuzzy.AddMust(query)
+			required = true
+		case "-":
+			fuzzy.AddMustNot(query)
+		default:
+			fuzzy.AddShould(query)
+		}
+	}
+	if !fuzzed {
+		return exact, nil
+	}
+	if !required {
+		fuzzy.SetMinShould(1)
+	}
+
+	rv := bluge.NewBooleanQuery().AddShould(exact, fuzzy)
+	rv.SetMinShould(1)
+	return rv, nil
+}
+
+// fuzzyAlternatives matches the word of the clause as the query does, or
+// fuzzily in the fields searched, or as corrected
+func fuzzyAlternatives(clause *queryClause, query bluge.Query, corrections map[string]string) (bluge.Query, error) {
+	alternatives := []bluge.Query{query}
+	for _, field := range fuzzyFields {
+		if clause.field != "" && analyzedField(clause.field) != field {
+			continue
+		}
+		fieldAnalyzer := textAnalysis.Analyzer(field, "")
+		if fieldAnalyzer == nil {
+			fieldAnalyzer = analyzers["standard"]
+		}
+		term := analyzeText(fieldAnalyzer, clause.word)
+		if term == "" || strings.Contains(term, " ") {
+			continue
+		}
+		alternatives = append(alternatives, bluge.NewFuzzyQuery(term).
+			SetField(field).
+			SetFuzziness(maxEdits(term)).
+			SetPrefix(1).
+			SetBoost(fuzzyBoost))
+	}
+	if correction, ok := corrections[clause.word]; ok {
+		corrected, err := parseQueryString(clause.format("", correction))
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, corrected)
+	}
+	rv := bluge.NewBooleanQuery().AddShould(alternatives...)
+	rv.SetMinShould(1)
+	return rv, nil
+}
+
+// searchFuzzy retries a search which found too few hits with its words
+// which may be misspelled fuzzy matched, keeping the results with the most
+// hits
+func searchFuzzy(r *SearchRequest, results *searchResults, readers ...*bluge.Reader) (*searchResults, error) {
+	err := r.CorrectSpelling(readers...)
+	if err != nil {
+		return nil, err
+	}
+	blugeRequest, err := r.BlugeRequest()
+	if err != nil {
+		return nil, err
+	}
+	fuzzyResults, err := searchHits(blugeRequest, r, readers...)
+	if err != nil {
+		return nil, err
+	}
+	if fuzzyResults.aggs.Count() <= results.aggs.Count() {
+		// neither the fuzzy matches nor the corrections are worth reporting
+		r.fuzzy = false
+		r.corrections = nil
+		return results, nil
+	}
+	return fuzzyResults, nil
+}
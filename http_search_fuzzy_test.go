@@ -0,0 +1,123 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		max    int
+		expect int
+	}{
+		{a: "guiness", b: "guinness", max: 2, expect: 1},
+		{a: "kolsch", b: "kolsch", max: 2, expect: 0},
+		{a: "porter", b: "potrer", max: 2, expect: 2},
+		{a: "stout", b: "trout", max: 1, expect: 2},
+		{a: "ale", b: "amber", max: 1, expect: 2},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.a+"-"+test.b, func(t *testing.T) {
+			got := editDistance(test.a, test.b, test.max)
+			if got != test.expect {
+				t.Errorf("expected distance %d, got %d", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	guinness := func(filename string) bool {
+		return strings.HasPrefix(filename, "arthur_guinness_son")
+	}
+	beerReader := openTestIndex(t, typeBeer, guinness)
+	breweryReader := openTestIndex(t, typeBrewery, guinness)
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
+
+	tests := []struct {
+		name             string
+		query            string
+		expectDidYouMean string
+		expectHits       bool
+	}{
+		{
+			name:             "misspelled",
+			query:            "guiness",
+			expectDidYouMean: "guinness",
+			expectHits:       true,
+		},
+		{
+			name:             "required and field",
+			query:            "+name:Guiness +stout",
+			expectDidYouMean: "+name:guinness +stout",
+			expectHits:       true,
+		},
+		{
+			name:       "spelled right",
+			query:      "guinness",
+			expectHits: true,
+		},
+		{
+			name:       "excluded",
+			query:      "-guiness",
+			expectHits: true,
+		},
+		{
+			name:  "no word alike",
+			query: "zymurgy",
+		},
+		{
+			// the correction isn't suggested when it finds no more hits
+			name:  "corrected finding nothing more",
+			query: "+guiness +zymurgy",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			r := &SearchRequest{Query: test.query}
+			exactRequest, err := r.BlugeRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			exact, err := searchHits(exactRequest, r, beerReader, breweryReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := searchFuzzy(r, exact, beerReader, breweryReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.DidYouMean(); got != test.expectDidYouMean {
+				t.Errorf("expected did you mean '%s', got '%s'", test.expectDidYouMean, got)
+			}
+			if test.expectHits != (len(results.hits) > 0) {
+				t.Errorf("expected hits: %t, got %d", test.expectHits, len(results.hits))
+			}
+			if r.fuzzy && results.aggs.Count() <= exact.aggs.Count() {
+				t.Errorf("expected fuzzy matching to find more than %d hits", exact.aggs.Count())
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+)
+
+const defaultGroupTopHits = 3
+const maxGroupTopHits = 100
+
+// maxGroupScan is the most matches visited while grouping, matches beyond
+// it are left out of the groups and their counts, and the groups are
+// reported as truncated
+var maxGroupScan = 10000
+
+// missingSortValue is the sort value bluge gives documents without a value,
+// keyword values are UTF-8 so never contain these bytes
+var missingSortValue = bytes.Repeat([]byte{0xff}, 10)
+
+// GroupRequest collapses the results on a keyword field, returning the
+// best hits of each distinct value.  Groups are ordered by their best hit,
+// and paged like the hits.  Documents without a value are not collapsed,
+// they each form a group of their own.
+type GroupRequest struct {
+	Field   string `json:"field"`
+	Size    int    `json:"size"`
+	TopHits int    `json:"top_hits"`
+}
+
+func (g *GroupRequest) Validate() error {
+	if _, ok := termsFacetFields[g.Field]; !ok {
+		names := make([]string, 0, len(termsFacetFields))
+		for name := range termsFacetFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unable to group by '%s', expected one of: %s", g.Field, strings.Join(names, ", "))
+	}
+	if g.Size < 0 || g.Size > maxResultsPerPage {
+		return fmt.Errorf("group size must be between 0 and %d, got %d", maxResultsPerPage, g.Size)
+	}
+	if g.TopHits < 0 || g.TopHits > maxGroupTopHits {
+		return fmt.Errorf("group top_hits must be between 0 and %d, got %d", maxGroupTopHits, g.TopHits)
+	}
+	return nil
+}
+
+func (g *GroupRequest) topHits() int {
+	if g.TopHits > 0 {
+		return g.TopHits
+	}
+	return defaultGroupTopHits
+}
+
+// Group is one distinct value of the field grouped on
+type Group struct {
+	Value       string           `json:"value"`
+	DisplayName string           `json:"display_name"`
+	Count       uint64           `json:"count"`
+	Hits        []*DocumentMatch `json:"hits"`
+}
+
+// GroupedHits is the page of groups, Total counts every group
+type GroupedHits struct {
+	Field  string   `json:"field"`
+	Total  int      `json:"total"`
+	Groups []*Group `json:"groups"`
+	// Truncated is set when more than maxGroupScan matches were found, the
+	// total and counts then only include the matches visited
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// GroupSearchRequest builds the request visiting the matches in the
+// requested order, with the value grouped on as the last sort value.  It
+// expects BlugeRequest to have validated the request.
+func (r *SearchRequest) GroupSearchRequest() (bluge.SearchRequest, error) {
+	userQuery, err := r.UserQuery()
+	if err != nil {
+		return nil, err
+	}
+	sortOrder, err := r.SortOrder()
+	if err != nil {
+		return nil, err
+	}
+	sortOrder = append(sortOrder, search.SortBy(copyingValueSource{search.Field(termsFacetFields[r.Group.Field])}))
+
+	q := bluge.NewBooleanQuery().
+		AddMust(userQuery).
+		AddMust(r.buildFilterClauses()...)
+	// one more match than is visited reveals that the groups are truncated
+	rv := bluge.NewTopNSearch(maxGroupScan+1, q).
+		SortByCustom(sortOrder)
+	if r.Highlight {
+		rv.IncludeLocations()
+	}
+	return rv, nil
+}
+
+// searchGroups runs the group search request, and collects the page of
+// groups the search request asks for
+func searchGroups(r *SearchRequest, beerReader, breweryReader *bluge.Reader) (*GroupedHits, error) {
+	groupRequest, err := r.GroupSearchRequest()
+	if err != nil {
+		return nil, err
+	}
+	dmi, err := bluge.MultiSearch(context.Background(), groupRequest, beerReader, breweryReader)
+	if err != nil {
+		return nil, err
+	}
+
+	size := r.Group.Size
+	if size == 0 {
+		size = r.Size
+	}
+	offset := (r.Page - 1) * size
+	rv := &GroupedHits{
+		Field: r.Group.Field,
+	}
+	groups := make(map[string]*Group)
+	paged := make(map[*Group]bool)
+	var scanned int
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		if scanned == maxGroupScan {
+			rv.Truncated = true
+			break
+		}
+		scanned++
+		var value string
+		if sortValue := next.SortValue[len(next.SortValue)-1]; !bytes.Equal(sortValue, missingSortValue) {
+			value = string(sortValue)
+		}
+		key := value
+		if value == "" {
+			// documents without a value are groups of their own
+			key = fmt.Sprintf("\x00%d", rv.Total)
+		}
+		group, ok := groups[key]
+		if !ok {
+			group = &Group{
+				Value:       value,
+				DisplayName: valueDisplayName(r.Group.Field, value),
+			}
+			groups[key] = group
+			if rv.Total >= offset && rv.Total < offset+size {
+				rv.Groups = append(rv.Groups, group)
+				paged[group] = true
+			}
+			rv.Total++
+		}
+		group.Count++
+		if paged[group] && len(group.Hits) < r.Group.topHits() {
+			var hit *DocumentMatch
+			hit, err = newDocumentMatch(next, r)
+			if err != nil {
+				return nil, err
+			}
+			group.Hits = append(group.Hits, hit)
+		}
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = rv.loadBreweries(breweryReader, r)
+	if err != nil {
+		return nil, fmt.Errorf("error loading breweries: %v", err)
+	}
+	return rv, nil
+}
+
+// loadBreweries names groups of beers by brewery after the brewery, and
+// embeds the breweries in the hits when the request asks for them
+func (g *GroupedHits) loadBreweries(breweryReader *bluge.Reader, r *SearchRequest) error {
+	if g.Field == breweryAggregation {
+		ids := make([]string, 0, len(g.Groups))
+		for _, group := range g.Groups {
+			ids = append(ids, group.Value)
+		}
+		breweries, err := loadBreweries(breweryReader, ids)
+		if err != nil {
+			return err
+		}
+		for _, group := range g.Groups {
+			if brewery, ok := breweries[group.Value]; ok && brewery.Name != "" {
+				group.DisplayName = brewery.Name
+			}
+		}
+	}
+	if !r.IncludeBrewery {
+		return nil
+	}
+	var hits []*DocumentMatch
+	for _, group := range g.Groups {
+		hits = append(hits, group.Hits...)
+	}
+	return embedBreweries(breweryReader, hits, r)
+}
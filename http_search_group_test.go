@@ -0,0 +1,164 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestGroupRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *GroupRequest
+		wantErr bool
+	}{
+		{
+			name: "brewery",
+			in:   &GroupRequest{Field: "brewery_id"},
+		},
+		{
+			name: "style with sizes",
+			in:   &GroupRequest{Field: "style-facet", Size: 20, TopHits: 5},
+		},
+		{
+			name:    "text field",
+			in:      &GroupRequest{Field: "desc"},
+			wantErr: true,
+		},
+		{
+			name:    "too many hits",
+			in:      &GroupRequest{Field: "brewery_id", TopHits: 1000},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.in.Validate()
+			if test.wantErr && err == nil {
+				t.Errorf("expected error, got nil")
+			} else if !test.wantErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func openTestIndex(t *testing.T, docType string, keep func(filename string) bool) *bluge.Reader {
+	indexWriter, err := bluge.OpenWriter(bluge.InMemoryOnlyConfig().
+		WithVirtualField(bluge.NewKeywordField("_type", docType).StoreValue().Aggregatable()))
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	files, err := ioutil.ReadDir("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := bluge.NewBatch()
+	for _, file := range files {
+		if _, fileType := docIDFromFilename(file.Name()); fileType != docType || !keep(file.Name()) {
+			continue
+		}
+		_, doc, err := parseAndBuildDoc("data", file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch.Update(doc.ID(), doc)
+	}
+	err = indexWriter.Batch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return indexReader
+}
+
+func TestSearchGroups(t *testing.T) {
+	yuengling := func(filename string) bool {
+		return strings.HasPrefix(filename, "yuengling_son_brewing")
+	}
+	beerReader := openTestIndex(t, typeBeer, yuengling)
+	breweryReader := openTestIndex(t, typeBrewery, yuengling)
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
+
+	searchRequest := &SearchRequest{
+		Query: "type:beer",
+		Sort:  []string{"name"},
+		Group: &GroupRequest{Field: "style-facet", Size: 2, TopHits: 1},
+	}
+	_, err := searchRequest.BlugeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouped, err := searchGroups(searchRequest, beerReader, breweryReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Black and Tan has no style, so it is a group of its own
+	if grouped.Total != 6 {
+		t.Errorf("expected 6 groups, got %d", grouped.Total)
+	}
+	if len(grouped.Groups) != 2 {
+		t.Fatalf("expected a page of 2 groups, got %d", len(grouped.Groups))
+	}
+	expect := []string{"American-Style Pale Ale", ""}
+	for i, group := range grouped.Groups {
+		if group.Value != expect[i] {
+			t.Errorf("expected group %d to be %s, got %s", i, expect[i], group.Value)
+		}
+		if len(group.Hits) != 1 {
+			t.Errorf("expected 1 hit in group %s, got %d", group.Value, len(group.Hits))
+		}
+	}
+
+	searchRequest.Group = &GroupRequest{Field: "brewery_id"}
+	grouped, err = searchGroups(searchRequest, beerReader, breweryReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grouped.Total != 1 || grouped.Groups[0].Count != 8 || len(grouped.Groups[0].Hits) != defaultGroupTopHits {
+		t.Fatalf("expected one brewery with 8 beers, got %d groups", grouped.Total)
+	}
+	if grouped.Groups[0].DisplayName != "Yuengling & Son Brewing" {
+		t.Errorf("expected the group to be named after the brewery, got '%s'", grouped.Groups[0].DisplayName)
+	}
+	if grouped.Truncated {
+		t.Errorf("expected all 8 beers to be grouped")
+	}
+
+	defer func(prev int) {
+		maxGroupScan = prev
+	}(maxGroupScan)
+	maxGroupScan = 5
+	grouped, err = searchGroups(searchRequest, beerReader, breweryReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !grouped.Truncated || grouped.Groups[0].Count != 5 {
+		t.Errorf("expected the groups to be truncated to 5 beers, got truncated: %t with %d beers",
+			grouped.Truncated, grouped.Groups[0].Count)
+	}
+}
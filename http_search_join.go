@@ -0,0 +1,220 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blugelabs/bluge"
+)
+
+// breweryFilterNames are the filters which may be applied to breweries
+// when joining beers to them
+var breweryFilterNames = map[string]struct{}{
+	countryAggregation: {},
+	stateAggregation:   {},
+	cityAggregation:    {},
+}
+
+// BreweryJoin restricts results to the beers brewed by breweries matching
+// its filters.  The filters apply to the brewery index, the beers are then
+// matched by their brewery_id.
+type BreweryJoin struct {
+	Filters []*Filter  `json:"filters"`
+	Geo     *GeoFilter `json:"geo"`
+}
+
+func (j *BreweryJoin) Validate() error {
+	if len(j.Filters) == 0 && j.Geo == nil {
+		return fmt.Errorf("brewery join requires filters or a geo filter")
+	}
+	for _, filter := range j.Filters {
+		if _, ok := breweryFilterNames[filter.Name]; !ok {
+			return fmt.Errorf("unable to filter breweries by '%s'", filter.Name)
+		}
+		err := filter.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if j.Geo != nil {
+		err := j.Geo.Validate()
+		if err != nil {
+			return err
+		}
+		if j.Geo.SortByDistance {
+			return fmt.Errorf("sorting by brewery distance is not supported")
+		}
+	}
+	return nil
+}
+
+// BreweryIDs finds the IDs of all the breweries matching the join
+func (j *BreweryJoin) BreweryIDs(breweryReader *bluge.Reader) ([]string, error) {
+	count, err := breweryReader.Count()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	filters := &SearchRequest{Filters: j.Filters, Geo: j.Geo}
+	q := bluge.NewBooleanQuery().
+		AddMust(bluge.NewTermQuery("brewery").SetField("type")).
+		AddMust(filters.buildFilterClauses()...)
+	dmi, err := breweryReader.Search(context.Background(), bluge.NewTopNSearch(int(count), q))
+	if err != nil {
+		return nil, err
+	}
+	var rv []string
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		err = next.VisitStoredFields(func(field string, value []byte) bool {
+			if field == "_id" {
+				rv = append(rv, string(value))
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// JoinBreweries resolves the brewery join, if the request has one, into
+// the IDs of the breweries the beers must belong to
+func (r *SearchRequest) JoinBreweries(breweryReader *bluge.Reader) error {
+	if r.Brewery == nil {
+		return nil
+	}
+	err := r.Brewery.Validate()
+	if err != nil {
+		return err
+	}
+	r.breweryIDs, err = r.Brewery.BreweryIDs(breweryReader)
+	if err != nil {
+		return fmt.Errorf("error joining breweries: %v", err)
+	}
+	return nil
+}
+
+// breweryJoinClause matches the beers of the joined breweries, breweries
+// themselves have no brewery_id so they never match
+func (r *SearchRequest) breweryJoinClause() bluge.Query {
+	if len(r.breweryIDs) == 0 {
+		return bluge.NewMatchNoneQuery()
+	}
+	rv := bluge.NewBooleanQuery()
+	for _, breweryID := range r.breweryIDs {
+		rv.AddShould(bluge.NewTermQuery(breweryID).SetField(breweryAggregation))
+	}
+	rv.SetMinShould(1)
+	return rv
+}
+
+// JoinedBrewery is the brewery embedded in a beer hit
+type JoinedBrewery struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	City     string   `json:"city"`
+	State    string   `json:"state"`
+	Country  string   `json:"country"`
+	Location GeoPoint `json:"location"`
+}
+
+// loadBreweries loads the breweries with the provided IDs, keyed by ID
+func loadBreweries(breweryReader *bluge.Reader, ids []string) (map[string]*Brewery, error) {
+	rv := make(map[string]*Brewery, len(ids))
+	if len(ids) == 0 {
+		return rv, nil
+	}
+	q := bluge.NewBooleanQuery()
+	for _, id := range ids {
+		q.AddShould(bluge.NewTermQuery(id).SetField("_id"))
+	}
+	dmi, err := breweryReader.Search(context.Background(), bluge.NewTopNSearch(len(ids), q))
+	if err != nil {
+		return nil, err
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		var docID string
+		var doc Indexable
+		docID, doc, err = matchToIndexable(next)
+		if err != nil {
+			return nil, err
+		}
+		if brewery, ok := doc.(*Brewery); ok {
+			rv[docID] = brewery
+		}
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// embedBreweries adds the brewery of each beer hit to the hit, when the
+// brewery join has a geo center, the distance of the hit is the distance
+// to its brewery
+func embedBreweries(breweryReader *bluge.Reader, hits []*DocumentMatch, r *SearchRequest) error {
+	var ids []string
+	seen := make(map[string]struct{})
+	for _, hit := range hits {
+		if beer, ok := hit.Document.(*Beer); ok {
+			if _, ok := seen[beer.BreweryID]; !ok {
+				seen[beer.BreweryID] = struct{}{}
+				ids = append(ids, beer.BreweryID)
+			}
+		}
+	}
+	breweries, err := loadBreweries(breweryReader, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, hit := range hits {
+		beer, ok := hit.Document.(*Beer)
+		if !ok {
+			continue
+		}
+		brewery, ok := breweries[beer.BreweryID]
+		if !ok {
+			continue
+		}
+		hit.Brewery = &JoinedBrewery{
+			ID:       beer.BreweryID,
+			Name:     brewery.Name,
+			City:     brewery.City,
+			State:    brewery.State,
+			Country:  brewery.Country,
+			Location: brewery.Geo,
+		}
+		if r.Brewery != nil && r.Brewery.Geo != nil && r.Brewery.Geo.Center != nil {
+			distance := r.Brewery.Geo.DistanceTo(brewery.Geo)
+			hit.Distance = &distance
+		}
+	}
+	return nil
+}
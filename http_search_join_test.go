@@ -0,0 +1,146 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestBreweryJoinValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *BreweryJoin
+		wantErr bool
+	}{
+		{
+			name: "filters",
+			in:   &BreweryJoin{Filters: []*Filter{{Name: "state-facet", Value: "California"}}},
+		},
+		{
+			name: "geo",
+			in: &BreweryJoin{Geo: &GeoFilter{
+				Center:   &GeoPoint{Lat: 40.7, Lon: -76.1747},
+				Distance: "25km",
+			}},
+		},
+		{
+			name:    "empty",
+			in:      &BreweryJoin{},
+			wantErr: true,
+		},
+		{
+			name:    "beer filter",
+			in:      &BreweryJoin{Filters: []*Filter{{Name: "style-facet", Value: "Porter"}}},
+			wantErr: true,
+		},
+		{
+			name: "sort by distance",
+			in: &BreweryJoin{Geo: &GeoFilter{
+				Center:         &GeoPoint{Lat: 40.7, Lon: -76.1747},
+				Distance:       "25km",
+				SortByDistance: true,
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.in.Validate()
+			if test.wantErr && err == nil {
+				t.Errorf("expected error, got nil")
+			} else if !test.wantErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestBreweryJoinBreweryIDs(t *testing.T) {
+	indexWriter, err := bluge.OpenWriter(bluge.InMemoryOnlyConfig())
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	batch := bluge.NewBatch()
+	for _, filename := range []string{"yuengling_son_brewing.json", "21st_amendment_brewery_cafe.json"} {
+		_, doc, err := parseAndBuildDoc("data", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch.Update(doc.ID(), doc)
+	}
+	err = indexWriter.Batch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = indexReader.Close()
+	}()
+
+	tests := []struct {
+		name   string
+		in     *BreweryJoin
+		expect []string
+	}{
+		{
+			name:   "state",
+			in:     &BreweryJoin{Filters: []*Filter{{Name: "state-facet", Value: "California"}}},
+			expect: []string{"21st_amendment_brewery_cafe"},
+		},
+		{
+			name: "either state",
+			in: &BreweryJoin{Filters: []*Filter{
+				{Name: "state-facet", Value: "California"},
+				{Name: "state-facet", Value: "Pennsylvania"},
+			}},
+			expect: []string{"21st_amendment_brewery_cafe", "yuengling_son_brewing"},
+		},
+		{
+			name: "distance",
+			in: &BreweryJoin{Geo: &GeoFilter{
+				Center:   &GeoPoint{Lat: 40.68, Lon: -76.19},
+				Distance: "30km",
+			}},
+			expect: []string{"yuengling_son_brewing"},
+		},
+		{
+			name: "no match",
+			in:   &BreweryJoin{Filters: []*Filter{{Name: "country-facet", Value: "Atlantis"}}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.in.BreweryIDs(indexReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected breweries: %v, got: %v", test.expect, got)
+			}
+		})
+	}
+}
@@ -15,13 +15,19 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
 	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/blugelabs/bluge/numeric/geo"
 	"github.com/blugelabs/bluge/search"
-	"github.com/blugelabs/bluge/search/aggregations"
 
 	"github.com/blugelabs/bluge"
 	querystr "github.com/blugelabs/query_string"
@@ -74,79 +80,661 @@ type Filter struct {
 	Value string `json:"value"`
 }
 
+// Validate checks that the values of range filters parse, rather than the
+// filters being left out of the search
+func (f *Filter) Validate() error {
+	switch f.Name {
+	case abvAggregation, ibuAggregation, srmAggregation, beerCountAggregation:
+		if _, ok := abvRanges[f.Value]; ok && f.Name == abvAggregation {
+			return nil
+		}
+		if _, _, ok := parseNumericRange(f.Value); !ok {
+			return fmt.Errorf("error parsing %s filter '%s', expected a range in the form start-end", f.Name, f.Value)
+		}
+	case updatedAggregation:
+		if _, ok := updatedRanges[f.Value]; ok {
+			return nil
+		}
+		if _, _, ok := parseDateRange(f.Value); !ok {
+			return fmt.Errorf("error parsing updated filter '%s', expected a day, a calendar period or a range "+
+				"in the form start..end", f.Value)
+		}
+	}
+	return nil
+}
+
+// GeoFilter restricts results to documents with a location within Distance
+// of Center, and optionally inside a bounding box or polygon.
+type GeoFilter struct {
+	Center         *GeoPoint  `json:"center"`
+	Distance       string     `json:"distance"`
+	TopLeft        *GeoPoint  `json:"top_left"`
+	BottomRight    *GeoPoint  `json:"bottom_right"`
+	Polygon        []GeoPoint `json:"polygon"`
+	Unit           string     `json:"unit"`
+	SortByDistance bool       `json:"sort_by_distance"`
+}
+
+func (g *GeoFilter) Validate() error {
+	if g.Center == nil && g.TopLeft == nil && g.BottomRight == nil && len(g.Polygon) == 0 {
+		return fmt.Errorf("geo filter requires a center, bounding box or polygon")
+	}
+	if g.Center != nil {
+		if g.Distance == "" {
+			return fmt.Errorf("geo filter with a center requires a distance")
+		}
+		if _, err := geo.ParseDistance(g.Distance); err != nil {
+			return fmt.Errorf("error parsing geo distance '%s': %v", g.Distance, err)
+		}
+	}
+	if (g.TopLeft == nil) != (g.BottomRight == nil) {
+		return fmt.Errorf("geo bounding box requires both top_left and bottom_right")
+	}
+	if len(g.Polygon) > 0 && len(g.Polygon) < 3 {
+		return fmt.Errorf("geo polygon requires at least 3 points, got %d", len(g.Polygon))
+	}
+	if g.SortByDistance && g.Center == nil {
+		return fmt.Errorf("sorting by distance requires a geo center")
+	}
+	if _, err := g.unitMultiplier(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (g *GeoFilter) unitMultiplier() (float64, error) {
+	if g.Unit == "" {
+		return geo.ParseDistanceUnit("km")
+	}
+	return geo.ParseDistanceUnit(g.Unit)
+}
+
+func (g *GeoFilter) buildFilterClauses() (rv []bluge.Query) {
+	if g.Center != nil {
+		rv = append(rv, bluge.NewGeoDistanceQuery(g.Center.Lon, g.Center.Lat, g.Distance).
+			SetField(locationField))
+	}
+	if g.TopLeft != nil && g.BottomRight != nil {
+		rv = append(rv, bluge.NewGeoBoundingBoxQuery(g.TopLeft.Lon, g.TopLeft.Lat,
+			g.BottomRight.Lon, g.BottomRight.Lat).SetField(locationField))
+	}
+	if len(g.Polygon) > 0 {
+		points := make([]geo.Point, 0, len(g.Polygon))
+		for _, p := range g.Polygon {
+			points = append(points, geo.Point{Lon: p.Lon, Lat: p.Lat})
+		}
+		rv = append(rv, bluge.NewGeoBoundingPolygonQuery(points).SetField(locationField))
+	}
+	return rv
+}
+
+// DistanceSort orders matches by their distance from the center, nearest first.
+func (g *GeoFilter) DistanceSort() *search.Sort {
+	return search.SortBy(search.NewGeoPointDistanceSource(search.Field(locationField),
+		search.NewConstantGeoPointSource(geo.Point{Lon: g.Center.Lon, Lat: g.Center.Lat}), geo.Kilometer))
+}
+
+// DistanceTo returns the distance from the center to the provided point,
+// in the requested unit.
+func (g *GeoFilter) DistanceTo(p GeoPoint) float64 {
+	// unit has already been checked by Validate
+	multiplier, _ := g.unitMultiplier()
+	return geo.Haversin(g.Center.Lon, g.Center.Lat, p.Lon, p.Lat) * 1000 / multiplier
+}
+
+// ParseFilter parses a filter in the form name:value
+func ParseFilter(in string) (*Filter, error) {
+	colon := strings.Index(in, ":")
+	if colon < 1 || colon == len(in)-1 {
+		return nil, fmt.Errorf("filter '%s' must be in the form name:value", in)
+	}
+	return &Filter{
+		Name:  in[:colon],
+		Value: in[colon+1:],
+	}, nil
+}
+
+// sortableFields maps the names clients may sort by to the index field
+var sortableFields = map[string]string{
+	"abv":     "abv",
+	"ibu":     "ibu",
+	"srm":     "srm",
+	"updated": "updated",
+	"name":    nameSortField,
+
+	beerCountAggregation: beerCountAggregation,
+	"abv_median":         "abv_median",
+	"ibu_median":         "ibu_median",
+}
+
+const sortScore = "_score"
+const sortDistance = "distance"
+
 type SearchRequest struct {
-	Query   string    `json:"query"`
-	Filters []*Filter `json:"filters"`
-	Page    int       `json:"page"`
+	Query     string          `json:"query"`
+	QueryDSL  json.RawMessage `json:"query_dsl"`
+	Filters   []*Filter       `json:"filters"`
+	Page      int             `json:"page"`
+	Geo       *GeoFilter      `json:"geo"`
+	Highlight bool            `json:"highlight"`
+	Sort      []string        `json:"sort"`
+	Size      int             `json:"size"`
+	Cursor    string          `json:"cursor"`
+
+	Aggregations []*AggregationRequest `json:"aggregations"`
+	Metrics      []*MetricRequest      `json:"metrics"`
+
+	Brewery        *BreweryJoin `json:"brewery"`
+	IncludeBrewery bool         `json:"include_brewery"`
+
+	Group *GroupRequest `json:"group"`
+
+	// Fuzzy retries searches finding few hits with fuzzy matching, unless
+	// it is false
+	Fuzzy *bool `json:"fuzzy"`
+
+	// breweryIDs are the breweries matching the brewery join
+	breweryIDs []string
+
+	// fuzzy is set when the query string is fuzzy matched, with the
+	// corrections of its misspelled words
+	fuzzy       bool
+	corrections map[string]string
 }
 
-func (r *SearchRequest) buildFilterClauses() (rv []bluge.Query) {
-	for _, filter := range r.Filters {
-		switch filter.Name {
-		case typeAggregation, styleAggregation:
-			rv = append(rv, bluge.NewTermQuery(filter.Value).SetField(filter.Name))
-		case abvAggregation:
-			if abvRange, ok := abvRanges[filter.Value]; ok {
-				rv = append(rv, bluge.NewNumericRangeQuery(abvRange.Low, abvRange.High).SetField(filter.Name))
+// searchParams are the URL parameters of a search, geo, group, metrics, the
+// brewery join and nested aggregations are only available in a POST body
+var searchParams = map[string]bool{
+	"q":               true,
+	"query_dsl":       true,
+	"filter":          true,
+	"sort":            true,
+	"aggregation":     true,
+	"page":            true,
+	"size":            true,
+	"cursor":          true,
+	"highlight":       true,
+	"include_brewery": true,
+	"fuzzy":           true,
+}
+
+// ParseSearchRequest builds a SearchRequest from the URL parameters q,
+// query_dsl (JSON), filter (name:value), sort and aggregation (names, each
+// may be repeated or separated by commas), page, size, cursor, highlight,
+// include_brewery and fuzzy.  Other parameters are rejected rather than
+// ignored.
+func ParseSearchRequest(params url.Values) (*SearchRequest, error) {
+	var unsupported []string
+	for name := range params {
+		if !searchParams[name] {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, fmt.Errorf("unsupported search parameters: %s (use a POST request for geo, group, metrics, "+
+			"brewery and nested aggregations)", strings.Join(unsupported, ", "))
+	}
+
+	rv := &SearchRequest{
+		Query:  params.Get("q"),
+		Cursor: params.Get("cursor"),
+		Sort:   listParam(params, "sort"),
+	}
+	if dsl := params.Get("query_dsl"); dsl != "" {
+		rv.QueryDSL = json.RawMessage(dsl)
+	}
+	for _, filterStr := range params["filter"] {
+		filter, err := ParseFilter(filterStr)
+		if err != nil {
+			return nil, err
+		}
+		rv.Filters = append(rv.Filters, filter)
+	}
+	if _, ok := params["aggregation"]; ok {
+		rv.Aggregations = []*AggregationRequest{}
+		for _, name := range listParam(params, "aggregation") {
+			rv.Aggregations = append(rv.Aggregations, &AggregationRequest{Name: name})
+		}
+	}
+
+	var err error
+	if rv.Page, err = intParam(params, "page"); err != nil {
+		return nil, err
+	}
+	if rv.Size, err = intParam(params, "size"); err != nil {
+		return nil, err
+	}
+	if rv.Fuzzy, err = boolParam(params, "fuzzy"); err != nil {
+		return nil, err
+	}
+	highlight, err := boolParam(params, "highlight")
+	if err != nil {
+		return nil, err
+	}
+	includeBrewery, err := boolParam(params, "include_brewery")
+	if err != nil {
+		return nil, err
+	}
+	rv.Highlight = highlight != nil && *highlight
+	rv.IncludeBrewery = includeBrewery != nil && *includeBrewery
+	return rv, nil
+}
+
+// intParam returns the URL parameter as a number, 0 when it is missing
+func intParam(params url.Values, name string) (int, error) {
+	str := params.Get(name)
+	if str == "" {
+		return 0, nil
+	}
+	rv, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %s '%s': %v", name, str, err)
+	}
+	return rv, nil
+}
+
+// boolParam returns the URL parameter as a boolean, nil when it is missing
+func boolParam(params url.Values, name string) (*bool, error) {
+	str := params.Get(name)
+	if str == "" {
+		return nil, nil
+	}
+	rv, err := strconv.ParseBool(str)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %v", name, str, err)
+	}
+	return &rv, nil
+}
+
+// listParam returns the values of the repeated URL parameter, splitting
+// values separated by commas
+func listParam(params url.Values, name string) []string {
+	var rv []string
+	for _, value := range params[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				rv = append(rv, item)
 			}
-		case updatedAggregation:
-			if updatedRange, ok := updatedRanges[filter.Value]; ok {
-				rv = append(rv, bluge.NewDateRangeQuery(updatedRange.Start, updatedRange.End).SetField(filter.Name))
+		}
+	}
+	return rv
+}
+
+// AggregationRequests are the facets to return, the default facets are
+// returned unless the request lists them, an empty list returns none
+func (r *SearchRequest) AggregationRequests() []*AggregationRequest {
+	if r.Aggregations == nil {
+		return defaultAggregationRequests()
+	}
+	return r.Aggregations
+}
+
+func (r *SearchRequest) validateAggregations() error {
+	aggregationRequests := r.AggregationRequests()
+	for _, aggregationRequest := range aggregationRequests {
+		err := aggregationRequest.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return validateMetrics(r.Metrics, aggregationRequests)
+}
+
+// UserQuery builds the query the user asked for, from the query string,
+// the query DSL, or both, in which case documents must match both
+func (r *SearchRequest) UserQuery() (bluge.Query, error) {
+	var rv []bluge.Query
+	hasDSL := len(r.QueryDSL) > 0 && string(r.QueryDSL) != "null"
+	if !hasDSL || strings.TrimSpace(r.Query) != "" {
+		userQuery, err := r.parseQueryString()
+		if err != nil {
+			return nil, fmt.Errorf("errror parsing query string '%s': %v", r.Query, err)
+		}
+		rv = append(rv, userQuery)
+	}
+	if hasDSL {
+		dslQuery, err := ParseQueryDSL(r.QueryDSL)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, dslQuery)
+	}
+	if len(rv) == 1 {
+		return rv[0], nil
+	}
+	return bluge.NewBooleanQuery().AddMust(rv...), nil
+}
+
+// parseQueryString parses the query string of the request, fuzzy matched
+// when retrying a search which found few hits
+func (r *SearchRequest) parseQueryString() (bluge.Query, error) {
+	if r.fuzzy {
+		return fuzzyQueryString(r.Query, r.corrections)
+	}
+	return parseQueryString(r.Query)
+}
+
+// parseQueryString parses the query string, matching documents matching
+// any of its variants with synonyms, analyzed for the fields searched
+func parseQueryString(q string) (bluge.Query, error) {
+	var variants []string
+	for _, synonymVariant := range synonyms.ExpandQueryString(q) {
+		for _, variant := range textAnalysis.AnalyzeQueryString(synonymVariant) {
+			if !containsString(variants, variant) {
+				variants = append(variants, variant)
 			}
 		}
+	}
+	queries := make([]bluge.Query, 0, len(variants))
+	for _, variant := range variants {
+		query, err := querystr.ParseQueryString(variant, querystr.DefaultOptions())
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	rv := bluge.NewBooleanQuery().AddShould(queries...)
+	rv.SetMinShould(1)
+	return rv, nil
+}
+
+func (f *Filter) buildClause() bluge.Query {
+	switch f.Name {
+	case typeAggregation, styleAggregation, categoryAggregation, countryAggregation, stateAggregation,
+		cityAggregation, breweryAggregation, mainStyleAggregation, mainCategoryAggregation:
+		return bluge.NewTermQuery(f.Value).SetField(f.Name)
+	case abvAggregation, ibuAggregation, srmAggregation, beerCountAggregation:
+		if abvRange, ok := abvRanges[f.Value]; ok && f.Name == abvAggregation {
+			return bluge.NewNumericRangeQuery(abvRange.Low, abvRange.High).SetField(f.Name)
+		}
+		if start, end, ok := parseNumericRange(f.Value); ok {
+			return bluge.NewNumericRangeQuery(start, end).SetField(f.Name)
+		}
+	case updatedAggregation:
+		if updatedRange, ok := updatedRanges[f.Value]; ok {
+			return bluge.NewDateRangeQuery(updatedRange.Start, updatedRange.End).SetField(f.Name)
+		}
+		if start, end, ok := parseDateRange(f.Value); ok {
+			return bluge.NewDateRangeQuery(start, end).SetField(f.Name)
+		}
+	}
+	return nil
+}
+
+func (r *SearchRequest) buildFilterClauses() []bluge.Query {
+	return r.buildFilterClausesExcept("")
+}
+
+// buildFilterClausesExcept builds one clause for each filter name, other
+// than the excluded one.  Filters sharing a name are OR'ed, so selecting
+// two styles finds documents of either style, while filters with
+// different names must all match.
+func (r *SearchRequest) buildFilterClausesExcept(exclude string) (rv []bluge.Query) {
+	var names []string
+	clauses := make(map[string][]bluge.Query)
+	for _, filter := range r.Filters {
+		appLog.Debug("filter", LogFields{"name": filter.Name, "value": filter.Value})
+		if filter.Name == exclude {
+			continue
+		}
+		clause := filter.buildClause()
+		if clause == nil {
+			continue
+		}
+		if _, seen := clauses[filter.Name]; !seen {
+			names = append(names, filter.Name)
+		}
+		clauses[filter.Name] = append(clauses[filter.Name], clause)
+	}
+
+	for _, name := range names {
+		if len(clauses[name]) == 1 {
+			rv = append(rv, clauses[name][0])
+			continue
+		}
+		anyOf := bluge.NewBooleanQuery().AddShould(clauses[name]...)
+		anyOf.SetMinShould(1)
+		rv = append(rv, anyOf)
+	}
 
-		log.Printf("see filter name: %s value: %s", filter.Name, filter.Value)
+	if r.Geo != nil {
+		rv = append(rv, r.Geo.buildFilterClauses()...)
+	}
+	if r.Brewery != nil {
+		rv = append(rv, r.breweryJoinClause())
 	}
 
 	return rv
 }
 
+func (r *SearchRequest) hasFilter(name string) bool {
+	for _, filter := range r.Filters {
+		if filter.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SortOrder builds the sort order from the list of sort keys, each key is
+// a sortable field name, optionally prefixed with - for descending order,
+// later keys break ties in earlier ones.  Without any keys, results are
+// sorted by distance when the geo filter asks for it, otherwise by score.
+// The document ID is always added as the final tie-break, so that every
+// hit has a unique position to resume from with a cursor.
+func (r *SearchRequest) SortOrder() (search.SortOrder, error) {
+	rv := make(search.SortOrder, 0, len(r.Sort)+2)
+	if len(r.Sort) == 0 {
+		if r.Geo != nil && r.Geo.SortByDistance {
+			rv = append(rv, r.Geo.DistanceSort())
+		} else {
+			rv = append(rv, search.SortBy(search.DocumentScore()).Desc())
+		}
+	}
+
+	for _, key := range r.Sort {
+		name := strings.TrimPrefix(key, "+")
+		descending := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		var sort *search.Sort
+		switch name {
+		case sortScore:
+			// higher scores are always better
+			sort = search.SortBy(search.DocumentScore()).Desc()
+			descending = false
+		case sortDistance:
+			if r.Geo == nil || r.Geo.Center == nil {
+				return nil, fmt.Errorf("sorting by distance requires a geo center")
+			}
+			sort = r.Geo.DistanceSort()
+		default:
+			field, ok := sortableFields[name]
+			if !ok {
+				return nil, fmt.Errorf("unable to sort by '%s', field is not sortable", name)
+			}
+			sort = search.SortBy(copyingValueSource{search.Field(field)})
+		}
+		if descending {
+			sort.Desc()
+		}
+		rv = append(rv, sort)
+	}
+	rv = append(rv, search.SortBy(copyingValueSource{search.Field("_id")}))
+	return rv, nil
+}
+
+// copyingValueSource copies values out of the underlying source, doc
+// values are backed by a buffer which is reused for the next document
+// visited, leaving sort values computed from them corrupted
+type copyingValueSource struct {
+	search.TextValueSource
+}
+
+func (c copyingValueSource) Value(match *search.DocumentMatch) []byte {
+	return append([]byte(nil), c.TextValueSource.Value(match)...)
+}
+
 func (r *SearchRequest) SizeOffset() (size, offset int) {
-	return resultsPerPage, (r.Page - 1) * resultsPerPage
+	if r.Cursor != "" {
+		return r.Size, 0
+	}
+	return r.Size, (r.Page - 1) * r.Size
+}
+
+type cursor struct {
+	Sort  []string `json:"sort"`
+	After [][]byte `json:"after"`
+}
+
+// EncodeCursor encodes the sort keys and the sort value of the last hit on a
+// page, the next page is requested by sending it back as the cursor
+func EncodeCursor(sort []string, sortValue [][]byte) (string, error) {
+	cursorBytes, err := json.Marshal(&cursor{
+		Sort:  sort,
+		After: sortValue,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursorBytes), nil
+}
+
+// DecodeCursor returns the sort value to search after, the cursor must have
+// been encoded for the same sort keys
+func DecodeCursor(sort []string, encoded string) ([][]byte, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cursor: %v", err)
+	}
+	var c cursor
+	err = json.Unmarshal(cursorBytes, &c)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cursor: %v", err)
+	}
+	if !reflect.DeepEqual(c.Sort, sort) {
+		return nil, fmt.Errorf("cursor was created for sort %v, not %v", c.Sort, sort)
+	}
+	return c.After, nil
 }
 
 func (r *SearchRequest) BlugeRequest() (bluge.SearchRequest, error) {
-	userQuery, err := querystr.ParseQueryString(r.Query, querystr.DefaultOptions())
+	userQuery, err := r.UserQuery()
 	if err != nil {
-		return nil, fmt.Errorf("errror parsing query string '%s': %v", r.Query, err)
+		return nil, err
 	}
 
 	if r.Page < 1 {
 		r.Page = 1
 	}
+	if r.Size < 1 {
+		r.Size = resultsPerPage
+	}
+	if r.Size > maxResultsPerPage {
+		r.Size = maxResultsPerPage
+	}
 
-	size, offset := r.SizeOffset()
+	for _, filter := range r.Filters {
+		err = filter.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	filters := r.buildFilterClauses()
+	if r.Geo != nil {
+		err = r.Geo.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = r.validateAggregations()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Group != nil {
+		err = r.Group.Validate()
+		if err != nil {
+			return nil, err
+		}
+		if r.Cursor != "" {
+			return nil, fmt.Errorf("grouped results are paged by page, not cursor")
+		}
+	}
+
+	sortOrder, err := r.SortOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	var after [][]byte
+	if r.Cursor != "" {
+		after, err = DecodeCursor(r.Sort, r.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if len(after) != len(sortOrder) {
+			return nil, fmt.Errorf("cursor does not match the requested sort order")
+		}
+	}
+
+	size, offset := r.SizeOffset()
 
 	q := bluge.NewBooleanQuery().
 		AddMust(userQuery).
-		AddMust(filters...)
+		AddMust(r.buildFilterClauses()...)
 
-	blugeRequest := bluge.NewTopNSearch(size, q).
+	// one hit more than the page reveals whether there is a next page
+	blugeRequest := bluge.NewTopNSearch(size+1, q).
 		WithStandardAggregations().
 		SetFrom(offset).
 		ExplainScores()
 
-	blugeRequest.AddAggregation(typeAggregation, aggregations.NewTermsAggregation(search.Field("_type"), 2))
-	styleAgg := aggregations.NewTermsAggregation(aggregations.FilterText(search.Field("style-facet"),
-		func(bytes []byte) bool {
-			return len(bytes) > 0
-		}), 5)
-	blugeRequest.AddAggregation(styleAggregation, styleAgg)
+	if r.Highlight {
+		blugeRequest.IncludeLocations()
+	}
 
-	updatedAgg := aggregations.DateRanges(search.Field("updated"))
-	for k, v := range updatedRanges {
-		log.Printf("start %v end %v", v.Start, v.End)
-		updatedAgg.AddRange(aggregations.NewNamedDateRange(k, v.Start, v.End))
+	blugeRequest.SortByCustom(sortOrder)
+	if after != nil {
+		blugeRequest.After(after)
 	}
-	blugeRequest.AddAggregation(updatedAggregation, updatedAgg)
 
-	abvAgg := aggregations.Ranges(search.Field("abv"))
-	for k, v := range abvRanges {
-		abvAgg.AddRange(aggregations.NamedRange(k, v.Low, v.High))
+	for _, aggregationRequest := range r.AggregationRequests() {
+		blugeRequest.AddAggregation(aggregationRequest.Name, aggregationRequest.Aggregation())
+	}
+	for _, metric := range r.Metrics {
+		blugeRequest.AddAggregation(metric.Key(), metric.Aggregation())
 	}
-	blugeRequest.AddAggregation(abvAggregation, abvAgg)
 
 	return blugeRequest, nil
 }
+
+// FacetRequests builds a request for each facet being filtered on, which
+// counts the facet values as if its own filters were not applied, while
+// the filters on other facets still constrain it.  This keeps the other
+// values of a facet selectable once one of them has been chosen.
+func (r *SearchRequest) FacetRequests() (map[string]bluge.SearchRequest, error) {
+	rv := make(map[string]bluge.SearchRequest)
+	for _, aggregationRequest := range r.AggregationRequests() {
+		name := aggregationRequest.Name
+		if !r.hasFilter(name) {
+			continue
+		}
+		userQuery, err := r.UserQuery()
+		if err != nil {
+			return nil, err
+		}
+		q := bluge.NewBooleanQuery().
+			AddMust(userQuery).
+			AddMust(r.buildFilterClausesExcept(name)...)
+		facetRequest := bluge.NewTopNSearch(0, q)
+		facetRequest.AddAggregation(name, aggregationRequest.Aggregation())
+		rv[name] = facetRequest
+	}
+	return rv, nil
+}
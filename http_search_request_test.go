@@ -0,0 +1,447 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeoFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *GeoFilter
+		wantErr bool
+	}{
+		{
+			name: "center and distance",
+			in: &GeoFilter{
+				Center:         &GeoPoint{Lat: 40.7, Lon: -76.1747},
+				Distance:       "25km",
+				SortByDistance: true,
+			},
+		},
+		{
+			name: "bounding box",
+			in: &GeoFilter{
+				TopLeft:     &GeoPoint{Lat: 41, Lon: -77},
+				BottomRight: &GeoPoint{Lat: 40, Lon: -75},
+			},
+		},
+		{
+			name:    "empty",
+			in:      &GeoFilter{},
+			wantErr: true,
+		},
+		{
+			name: "center without distance",
+			in: &GeoFilter{
+				Center: &GeoPoint{Lat: 40.7, Lon: -76.1747},
+			},
+			wantErr: true,
+		},
+		{
+			name: "half bounding box",
+			in: &GeoFilter{
+				TopLeft: &GeoPoint{Lat: 41, Lon: -77},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sort without center",
+			in: &GeoFilter{
+				TopLeft:        &GeoPoint{Lat: 41, Lon: -77},
+				BottomRight:    &GeoPoint{Lat: 40, Lon: -75},
+				SortByDistance: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown unit",
+			in: &GeoFilter{
+				Center:   &GeoPoint{Lat: 40.7, Lon: -76.1747},
+				Distance: "25km",
+				Unit:     "furlongs",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.in.Validate()
+			if test.wantErr && err == nil {
+				t.Errorf("expected error, got nil")
+			} else if !test.wantErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in      string
+		expect  *Filter
+		wantErr bool
+	}{
+		{
+			in:     "type:beer",
+			expect: &Filter{Name: "type", Value: "beer"},
+		},
+		{
+			in:     "style-facet:American-Style Pale Ale",
+			expect: &Filter{Name: "style-facet", Value: "American-Style Pale Ale"},
+		},
+		{
+			in:     "updated:2010:Q3",
+			expect: &Filter{Name: "updated", Value: "2010:Q3"},
+		},
+		{
+			in:      "type",
+			wantErr: true,
+		},
+		{
+			in:      ":beer",
+			wantErr: true,
+		},
+		{
+			in:      "type:",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.in, func(t *testing.T) {
+			filter, err := ParseFilter(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got filter: %#v", filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(filter, test.expect) {
+				t.Errorf("expected filter: %#v, got: %#v", test.expect, filter)
+			}
+		})
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		in      *Filter
+		wantErr bool
+	}{
+		{in: &Filter{Name: "style-facet", Value: "Porter"}},
+		{in: &Filter{Name: "abv", Value: "5-"}},
+		{in: &Filter{Name: "ibu", Value: "-10--5"}},
+		{in: &Filter{Name: "updated", Value: "2010-Q3"}},
+		{in: &Filter{Name: "abv", Value: "foo"}, wantErr: true},
+		{in: &Filter{Name: "abv", Value: "[5 TO"}, wantErr: true},
+		{in: &Filter{Name: "srm", Value: "10-5"}, wantErr: true},
+		{in: &Filter{Name: "updated", Value: "last week"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.in.Name+":"+test.in.Value, func(t *testing.T) {
+			err := test.in.Validate()
+			if test.wantErr && err == nil {
+				t.Errorf("expected error, got nil")
+			} else if !test.wantErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseSearchRequest(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		in      string
+		expect  *SearchRequest
+		wantErr bool
+	}{
+		{
+			in: "q=stout&filter=style-facet:Porter&page=2&sort=-abv",
+			expect: &SearchRequest{
+				Query:   "stout",
+				Filters: []*Filter{{Name: "style-facet", Value: "Porter"}},
+				Page:    2,
+				Sort:    []string{"-abv"},
+			},
+		},
+		{
+			in: "q=ipa&sort=-abv,name&sort=_score&size=5&highlight=true&include_brewery=1&fuzzy=false",
+			expect: &SearchRequest{
+				Query:          "ipa",
+				Sort:           []string{"-abv", "name", "_score"},
+				Size:           5,
+				Highlight:      true,
+				IncludeBrewery: true,
+				Fuzzy:          &disabled,
+			},
+		},
+		{
+			in: "aggregation=type,abv&aggregation=style-facet",
+			expect: &SearchRequest{
+				Aggregations: []*AggregationRequest{{Name: "type"}, {Name: "abv"}, {Name: "style-facet"}},
+			},
+		},
+		{
+			in:     "q=lager&aggregation=",
+			expect: &SearchRequest{Query: "lager", Aggregations: []*AggregationRequest{}},
+		},
+		{
+			in:      "q=stout&page=two",
+			wantErr: true,
+		},
+		{
+			in:      "q=stout&filter=style-facet",
+			wantErr: true,
+		},
+		{
+			in:      "q=stout&highlight=maybe",
+			wantErr: true,
+		},
+		{
+			in:      "q=stout&geo=40.7,-76.17&group=brewery_id",
+			wantErr: true,
+		},
+		{
+			in:      "q=stout&filters=style-facet:Porter",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.in, func(t *testing.T) {
+			params, err := url.ParseQuery(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseSearchRequest(params)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got request: %#v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected request: %#v, got: %#v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestSearchRequestSortOrder(t *testing.T) {
+	center := &GeoFilter{
+		Center:   &GeoPoint{Lat: 40.7, Lon: -76.1747},
+		Distance: "25km",
+	}
+	tests := []struct {
+		name       string
+		in         *SearchRequest
+		expectKeys int
+		wantErr    bool
+	}{
+		{
+			name:       "default",
+			in:         &SearchRequest{},
+			expectKeys: 2,
+		},
+		{
+			name:       "multiple keys",
+			in:         &SearchRequest{Sort: []string{"-abv", "+ibu", "name", "_score"}},
+			expectKeys: 5,
+		},
+		{
+			name:       "distance",
+			in:         &SearchRequest{Sort: []string{"distance", "-updated"}, Geo: center},
+			expectKeys: 3,
+		},
+		{
+			name:    "distance without center",
+			in:      &SearchRequest{Sort: []string{"distance"}},
+			wantErr: true,
+		},
+		{
+			name:    "not sortable",
+			in:      &SearchRequest{Sort: []string{"desc"}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			sortOrder, err := test.in.SortOrder()
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(sortOrder) != test.expectKeys {
+				t.Errorf("expected %d sort keys, got %d", test.expectKeys, len(sortOrder))
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	sort := []string{"-abv", "name"}
+	sortValue := [][]byte{{0x20, 0x01, 0xff}, []byte("yuengling lager"), []byte("yuengling_son_brewing-yuengling_lager")}
+
+	encoded, err := EncodeCursor(sort, sortValue)
+	if err != nil {
+		t.Fatalf("error encoding cursor: %v", err)
+	}
+	decoded, err := DecodeCursor(sort, encoded)
+	if err != nil {
+		t.Fatalf("error decoding cursor: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, sortValue) {
+		t.Errorf("expected sort value: %v, got: %v", sortValue, decoded)
+	}
+
+	_, err = DecodeCursor([]string{"abv"}, encoded)
+	if err == nil {
+		t.Errorf("expected error decoding cursor with a different sort")
+	}
+	_, err = DecodeCursor(sort, "not a cursor")
+	if err == nil {
+		t.Errorf("expected error decoding invalid cursor")
+	}
+}
+
+func TestSearchHitsCursorPages(t *testing.T) {
+	yuengling := func(filename string) bool {
+		return strings.HasPrefix(filename, "yuengling_son_brewing")
+	}
+	beerReader := openTestIndex(t, typeBeer, yuengling)
+	breweryReader := openTestIndex(t, typeBrewery, yuengling)
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
+
+	// the 8 beers, the last page has no cursor even when it is full
+	tests := []struct {
+		size        int
+		expectPages []int
+	}{
+		{size: 3, expectPages: []int{3, 3, 2}},
+		{size: 4, expectPages: []int{4, 4}},
+		{size: 8, expectPages: []int{8}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("size %d", test.size), func(t *testing.T) {
+			var pages []int
+			var cursor string
+			for len(pages) <= len(test.expectPages) {
+				r := &SearchRequest{Query: "type:beer", Sort: []string{"name"}, Size: test.size, Cursor: cursor}
+				blugeRequest, err := r.BlugeRequest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				results, err := searchHits(blugeRequest, r, beerReader, breweryReader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages = append(pages, len(results.hits))
+				if !results.more {
+					break
+				}
+				cursor, err = EncodeCursor(r.Sort, results.lastSortValue)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(pages, test.expectPages) {
+				t.Errorf("expected pages of %v hits, got %v", test.expectPages, pages)
+			}
+		})
+	}
+}
+
+func TestSearchRequestFilterGroups(t *testing.T) {
+	r := &SearchRequest{
+		Filters: []*Filter{
+			{Name: "style-facet", Value: "Porter"},
+			{Name: "type", Value: "beer"},
+			{Name: "style-facet", Value: "American-Style Stout"},
+			{Name: "abv", Value: "unknown"},
+		},
+	}
+
+	tests := []struct {
+		exclude      string
+		expectGroups int
+	}{
+		{
+			exclude:      "",
+			expectGroups: 2,
+		},
+		{
+			exclude:      "style-facet",
+			expectGroups: 1,
+		},
+		{
+			exclude:      "type",
+			expectGroups: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run("except "+test.exclude, func(t *testing.T) {
+			clauses := r.buildFilterClausesExcept(test.exclude)
+			if len(clauses) != test.expectGroups {
+				t.Errorf("expected %d filter clauses, got %d", test.expectGroups, len(clauses))
+			}
+		})
+	}
+
+	facetRequests, err := r.FacetRequests()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"style-facet", "type", "abv"} {
+		if _, ok := facetRequests[name]; !ok {
+			t.Errorf("expected a facet request for %s", name)
+		}
+	}
+	if len(facetRequests) != 3 {
+		t.Errorf("expected 3 facet requests, got %d", len(facetRequests))
+	}
+}
@@ -22,10 +22,13 @@ import (
 )
 
 type DocumentMatch struct {
-	Document interface{}         `json:"document"`
-	Score    float64             `json:"score"`
-	Expl     *search.Explanation `json:"explanation"`
-	ID       string              `json:"id"`
+	Document  interface{}         `json:"document"`
+	Score     float64             `json:"score"`
+	Expl      *search.Explanation `json:"explanation"`
+	ID        string              `json:"id"`
+	Distance  *float64            `json:"distance,omitempty"`
+	Fragments map[string][]string `json:"fragments,omitempty"`
+	Brewery   *JoinedBrewery      `json:"brewery,omitempty"`
 }
 
 type AggregationValue struct {
@@ -33,6 +36,9 @@ type AggregationValue struct {
 	FilterName  string `json:"filter_name"`
 	Count       uint64 `json:"count"`
 	Filtered    bool   `json:"filtered"`
+
+	Metrics      map[string]*Metric      `json:"metrics,omitempty"`
+	Aggregations map[string]*Aggregation `json:"aggregations,omitempty"`
 }
 
 type Aggregation struct {
@@ -46,11 +52,16 @@ type SearchResponse struct {
 	Total        uint64                  `json:"total"`
 	TopScore     float64                 `json:"top_score"`
 	Hits         []*DocumentMatch        `json:"hits"`
+	Groups       *GroupedHits            `json:"groups,omitempty"`
 	Duration     string                  `json:"duration"`
 	Aggregations map[string]*Aggregation `json:"aggregations"`
+	Metrics      map[string]*Metric      `json:"metrics,omitempty"`
 	Message      string                  `json:"message"`
 	PreviousPage int                     `json:"previousPage,omitempty"`
 	NextPage     int                     `json:"nextPage,omitempty"`
+	Cursor       string                  `json:"cursor,omitempty"`
+	Fuzzy        bool                    `json:"fuzzy,omitempty"`
+	DidYouMean   string                  `json:"did_you_mean,omitempty"`
 }
 
 func NewSearchResponse(query string) *SearchResponse {
@@ -59,57 +70,86 @@ func NewSearchResponse(query string) *SearchResponse {
 	}
 }
 
-func (s *SearchResponse) buildAggregation(aggs *search.Bucket, name string, filters []*Filter) {
+func buildAggregation(aggs *search.Bucket, request *AggregationRequest, filters []*Filter) *Aggregation {
 	agg := &Aggregation{
-		DisplayName: displayName(name),
-		FilterName:  name,
+		DisplayName: displayName(request.Name),
+		FilterName:  request.Name,
 	}
 
-	for _, bucket := range aggs.Buckets(name) {
+	for _, bucket := range aggs.Buckets(request.Name) {
 		aggVal := &AggregationValue{
-			DisplayName: displayName(bucket.Name()),
+			DisplayName: valueDisplayName(request.Name, bucket.Name()),
 			FilterName:  bucket.Name(),
 			Count:       bucket.Count(),
+			Metrics:     buildMetrics(bucket, request.Metrics),
 		}
 		for _, f := range filters {
-			if f.Name == name && f.Value == bucket.Name() {
+			if f.Name == request.Name && f.Value == bucket.Name() {
 				aggVal.Filtered = true
 			}
 		}
+		for _, sub := range request.Aggregations {
+			if aggVal.Aggregations == nil {
+				aggVal.Aggregations = make(map[string]*Aggregation)
+			}
+			aggVal.Aggregations[sub.Name] = buildAggregation(bucket, sub, filters)
+		}
 		agg.Values = append(agg.Values, aggVal)
 	}
 
-	s.Aggregations[name] = agg
+	return agg
+}
+
+// SetAggregation renders the buckets of a requested aggregation, replacing
+// any already rendered under its name
+func (s *SearchResponse) SetAggregation(aggs *search.Bucket, request *AggregationRequest, filters []*Filter) {
+	s.Aggregations[request.Name] = buildAggregation(aggs, request, filters)
 }
 
-func (s *SearchResponse) AddAggregations(aggs *search.Bucket, filters []*Filter) {
+func (s *SearchResponse) AddAggregations(aggs *search.Bucket, request *SearchRequest) {
 	s.Total = aggs.Count()
 	s.TopScore = aggs.Metric("max_score")
 	s.Duration = aggs.Duration().String()
 
 	s.Aggregations = make(map[string]*Aggregation)
-	s.buildAggregation(aggs, typeAggregation, filters)
-	s.buildAggregation(aggs, styleAggregation, filters)
-	s.buildAggregation(aggs, updatedAggregation, filters)
-	s.buildAggregation(aggs, abvAggregation, filters)
+	for _, aggregationRequest := range request.AggregationRequests() {
+		s.SetAggregation(aggs, aggregationRequest, request.Filters)
+	}
+	s.Metrics = buildMetrics(aggs, request.Metrics)
 }
 
-func (s *SearchResponse) AddPaging(aggs *search.Bucket, page int) {
-	numPages := int(math.Ceil(float64(aggs.Count()) / float64(resultsPerPage)))
-	if numPages > page {
-		s.NextPage = page + 1
-	}
-	if page != 1 {
-		s.PreviousPage = page - 1
-	}
+// AddPaging describes the page of results, pages are numbered unless the
+// request followed a cursor
+func (s *SearchResponse) AddPaging(aggs *search.Bucket, page, size int, cursor bool) {
+	if !cursor {
+		numPages := int(math.Ceil(float64(aggs.Count()) / float64(size)))
+		if numPages > page {
+			s.NextPage = page + 1
+		}
+		if page != 1 {
+			s.PreviousPage = page - 1
+		}
 
-	if page != 1 {
-		s.Message = fmt.Sprintf("Page %d of ", page)
+		if page != 1 {
+			s.Message = fmt.Sprintf("Page %d of ", page)
+		}
 	}
 	s.Message += fmt.Sprintf("%d results (%s)", aggs.Count(),
 		aggs.Duration().Round(roundDurationTo))
 }
 
+// valueDisplayName names a value of a facet, only the values of the type,
+// updated and abv facets have names of their own
+func valueDisplayName(facet, value string) string {
+	switch facet {
+	case typeAggregation, updatedAggregation, abvAggregation:
+		return displayName(value)
+	}
+	return value
+}
+
+const displayDateFormat = "Jan 2, 2006"
+
 func displayName(in string) string {
 	switch in {
 	case typeAggregation:
@@ -121,19 +161,39 @@ func displayName(in string) string {
 	case updatedAggregation:
 		return "Updated"
 	case "old":
-		return "Before 2012"
+		return "Before " + updatedRanges["old"].End.Format(displayDateFormat)
 	case "new":
-		return "Since 2012"
+		return "Since " + updatedRanges["new"].Start.Format(displayDateFormat)
 	case styleAggregation:
 		return "Style"
 	case abvAggregation:
 		return "ABV"
+	case ibuAggregation:
+		return "IBU"
+	case srmAggregation:
+		return "SRM"
 	case "low":
 		return "Low (< 3%)"
 	case "med":
 		return "Medium (3% - 5%)"
 	case "high":
 		return "High (> 5%)"
+	case categoryAggregation:
+		return "Category"
+	case countryAggregation:
+		return "Country"
+	case stateAggregation:
+		return "State"
+	case cityAggregation:
+		return "City"
+	case breweryAggregation:
+		return "Brewery"
+	case beerCountAggregation:
+		return "Beers"
+	case mainStyleAggregation:
+		return "Main Style"
+	case mainCategoryAggregation:
+		return "Main Category"
 	}
 	return in
 }
@@ -0,0 +1,332 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/analysis/analyzer"
+	"github.com/gorilla/mux"
+)
+
+const defaultSimilar = 10
+const maxSimilar = 50
+
+// maxSimilarTerms is the most description terms a similar beer is
+// preferred for sharing
+const maxSimilarTerms = 20
+
+// minSimilarTermLength leaves out short words, which say little about a beer
+const minSimilarTermLength = 3
+
+// similarProximity scores beers with a numeric value near the beer's, a
+// beer within near of the value is preferred over one within far
+var similarProximity = map[string]struct {
+	Near float64
+	Far  float64
+}{
+	"abv": {Near: 0.5, Far: 1.5},
+	"ibu": {Near: 5, Far: 15},
+	"srm": {Near: 3, Far: 8},
+}
+
+const similarStyleBoost = 3
+const similarCategoryBoost = 1.5
+const similarNearBoost = 2
+const similarFarBoost = 1
+
+type SimilarRequest struct {
+	ID             string
+	Size           int
+	Filters        []*Filter
+	IncludeBrewery bool
+}
+
+// ParseSimilarRequest builds a SimilarRequest for the beer with the ID from
+// the URL parameters size, filter (name:value, may be repeated) and
+// include_brewery
+func ParseSimilarRequest(id string, params url.Values) (*SimilarRequest, error) {
+	rv := &SimilarRequest{
+		ID:   id,
+		Size: defaultSimilar,
+	}
+	if sizeStr := params.Get("size"); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing size '%s': %v", sizeStr, err)
+		}
+		if size < 1 || size > maxSimilar {
+			return nil, fmt.Errorf("size must be between 1 and %d, got %d", maxSimilar, size)
+		}
+		rv.Size = size
+	}
+	for _, filterStr := range params["filter"] {
+		filter, err := ParseFilter(filterStr)
+		if err != nil {
+			return nil, err
+		}
+		err = filter.Validate()
+		if err != nil {
+			return nil, err
+		}
+		rv.Filters = append(rv.Filters, filter)
+	}
+	if includeStr := params.Get("include_brewery"); includeStr != "" {
+		include, err := strconv.ParseBool(includeStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing include_brewery '%s': %v", includeStr, err)
+		}
+		rv.IncludeBrewery = include
+	}
+	return rv, nil
+}
+
+// BlugeRequest builds the request for beers like the provided beer, other
+// than the beer itself.  Beers of the same style and category score
+// higher, as do beers with a nearby abv, ibu and srm, and beers sharing
+// the most distinctive terms of the description.
+func (r *SimilarRequest) BlugeRequest(beer *Beer, descTerms map[string]float64) bluge.SearchRequest {
+	similar := bluge.NewBooleanQuery()
+	if beer.Style != "" {
+		similar.AddShould(bluge.NewTermQuery(beer.Style).SetField(styleAggregation).SetBoost(similarStyleBoost))
+	}
+	if beer.Category != "" {
+		similar.AddShould(bluge.NewTermQuery(beer.Category).SetField(categoryAggregation).SetBoost(similarCategoryBoost))
+	}
+	values := []struct {
+		field string
+		value float64
+	}{{"abv", beer.ABV}, {"ibu", beer.IBU}, {"srm", beer.SRM}}
+	for _, fv := range values {
+		field, value := fv.field, fv.value
+		// zero is unknown
+		if value <= 0 {
+			continue
+		}
+		proximity := similarProximity[field]
+		similar.AddShould(
+			bluge.NewNumericRangeInclusiveQuery(value-proximity.Near, value+proximity.Near, true, true).
+				SetField(field).SetBoost(similarNearBoost),
+			bluge.NewNumericRangeInclusiveQuery(value-proximity.Far, value+proximity.Far, true, true).
+				SetField(field).SetBoost(similarFarBoost))
+	}
+	for term, boost := range descTerms {
+		similar.AddShould(bluge.NewTermQuery(term).SetField("desc").SetBoost(boost))
+	}
+	similar.SetMinShould(1)
+
+	q := bluge.NewBooleanQuery().
+		AddMust(similar).
+		AddMustNot(bluge.NewTermQuery(r.ID).SetField("_id"))
+	filters := (&SearchRequest{Filters: r.Filters}).buildFilterClauses()
+	if len(filters) > 0 {
+		q.AddMust(filters...)
+	}
+
+	return bluge.NewTopNSearch(r.Size, q).WithStandardAggregations()
+}
+
+// distinctiveTerms picks the terms of the text which best tell it apart
+// from the other documents, those frequent in the text and rare in the
+// field, boosted relative to the most distinctive term.  Terms found in no
+// other document are left out, they cannot make another document similar.
+// The text is analyzed the way the field is for documents in the language.
+func distinctiveTerms(reader *bluge.Reader, field, language, text string) (map[string]float64, error) {
+	docCount, err := reader.Count()
+	if err != nil {
+		return nil, err
+	}
+	termFreqs := make(map[string]int)
+	textAnalyzer := textAnalysis.Analyzer(field, language)
+	if textAnalyzer == nil {
+		textAnalyzer = analyzer.NewStandardAnalyzer()
+	}
+	for _, token := range textAnalyzer.Analyze([]byte(text)) {
+		if len(token.Term) >= minSimilarTermLength {
+			termFreqs[string(token.Term)]++
+		}
+	}
+
+	type scoredTerm struct {
+		term  string
+		score float64
+	}
+	scored := make([]scoredTerm, 0, len(termFreqs))
+	for term, freq := range termFreqs {
+		var docFreq uint64
+		docFreq, err = termDocFreq(reader, field, term)
+		if err != nil {
+			return nil, err
+		}
+		if docFreq < 2 {
+			continue
+		}
+		idf := math.Log(1 + float64(docCount)/float64(docFreq))
+		scored = append(scored, scoredTerm{term: term, score: float64(freq) * idf})
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].term < scored[j].term
+	})
+	if len(scored) > maxSimilarTerms {
+		scored = scored[:maxSimilarTerms]
+	}
+
+	rv := make(map[string]float64, len(scored))
+	for _, st := range scored {
+		rv[st.term] = st.score / scored[0].score
+	}
+	return rv, nil
+}
+
+// termDocFreq returns the number of documents with the term in the field
+func termDocFreq(reader *bluge.Reader, field, term string) (uint64, error) {
+	dict, err := reader.DictionaryIterator(field, nil, []byte(term), []byte(term+"\x00"))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = dict.Close()
+	}()
+	entry, err := dict.Next()
+	if err != nil || entry == nil || entry.Term() != term {
+		return 0, err
+	}
+	return entry.Count(), nil
+}
+
+type SimilarResponse struct {
+	ID       string           `json:"id"`
+	Total    uint64           `json:"total"`
+	Hits     []*DocumentMatch `json:"hits"`
+	Duration string           `json:"duration"`
+}
+
+// SimilarHandler finds the beers most like the beer with the {id} route
+// variable
+type SimilarHandler struct {
+	beerIndexWriter    *bluge.Writer
+	breweryIndexWriter *bluge.Writer
+	logger             *log.Logger
+}
+
+func NewSimilarHandler(beerIndexWriter, breweryIndexWriter *bluge.Writer, logger *log.Logger) *SimilarHandler {
+	return &SimilarHandler{
+		beerIndexWriter:    beerIndexWriter,
+		breweryIndexWriter: breweryIndexWriter,
+		logger:             logger,
+	}
+}
+
+func (h *SimilarHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	startTime := time.Now()
+
+	similarRequest, err := ParseSimilarRequest(mux.Vars(req)["id"], req.URL.Query())
+	if err != nil {
+		showError(w, req, err.Error(), 400, h.logger)
+		return
+	}
+
+	beerReader, breweryReader, err := openReaders(h.beerIndexWriter, h.breweryIndexWriter)
+	if err != nil {
+		showError(w, req, err.Error(), 400, h.logger)
+		return
+	}
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
+
+	beer, err := loadBeer(beerReader, similarRequest.ID)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error loading beer: %v", err), 500, h.logger)
+		return
+	}
+	if beer == nil {
+		showError(w, req, fmt.Sprintf("beer '%s' not found", similarRequest.ID), 404, h.logger)
+		return
+	}
+	beer.Language, err = breweryLanguage(breweryReader, beer.BreweryID)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error loading brewery: %v", err), 500, h.logger)
+		return
+	}
+	descTerms, err := distinctiveTerms(beerReader, "desc", beer.Language, beer.Description)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error analyzing description: %v", err), 500, h.logger)
+		return
+	}
+
+	dmi, err := beerReader.Search(context.Background(), similarRequest.BlugeRequest(beer, descTerms))
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error executing query: %v", err), 500, h.logger)
+		return
+	}
+	similarResponse := &SimilarResponse{
+		ID: similarRequest.ID,
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		var hit *DocumentMatch
+		hit, err = newDocumentMatch(next, &SearchRequest{})
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error restoring document from match: %v", err), 500, h.logger)
+			return
+		}
+		similarResponse.Hits = append(similarResponse.Hits, hit)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error executing query: %v", err), 500, h.logger)
+		return
+	}
+	similarResponse.Total = dmi.Aggregations().Count()
+	if similarRequest.IncludeBrewery {
+		err = embedBreweries(breweryReader, similarResponse.Hits, &SearchRequest{})
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error loading breweries: %v", err), 500, h.logger)
+			return
+		}
+	}
+	similarResponse.Duration = time.Since(startTime).String()
+
+	mustEncode(w, similarResponse)
+}
+
+// loadBeer restores the beer with the provided ID from its stored source,
+// or returns nil if there is no such beer
+func loadBeer(beerReader *bluge.Reader, docID string) (*Beer, error) {
+	source, err := loadSource(beerReader, docID)
+	if err != nil || source == nil {
+		return nil, err
+	}
+	obj, _, err := unmarshalByType(typeBeer, docID, source)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling source: %v", err)
+	}
+	beer, _ := obj.(*Beer)
+	return beer, nil
+}
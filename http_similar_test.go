@@ -0,0 +1,145 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSimilarRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		expect  *SimilarRequest
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			in:     "",
+			expect: &SimilarRequest{ID: "beer", Size: defaultSimilar},
+		},
+		{
+			name: "all parameters",
+			in:   "size=3&filter=abv:med&filter=brewery_id:stone_brewing_co&include_brewery=true",
+			expect: &SimilarRequest{
+				ID:   "beer",
+				Size: 3,
+				Filters: []*Filter{
+					{Name: "abv", Value: "med"},
+					{Name: "brewery_id", Value: "stone_brewing_co"},
+				},
+				IncludeBrewery: true,
+			},
+		},
+		{
+			name:    "size too large",
+			in:      "size=500",
+			wantErr: true,
+		},
+		{
+			name:    "bad filter",
+			in:      "filter=porter",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			params, err := url.ParseQuery(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseSimilarRequest("beer", params)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected request: %#v, got: %#v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestSimilarBeers(t *testing.T) {
+	beerReader := openTestIndex(t, typeBeer, func(filename string) bool {
+		return strings.HasPrefix(filename, "yuengling_son_brewing")
+	})
+	defer func() {
+		_ = beerReader.Close()
+	}()
+
+	beer, err := loadBeer(beerReader, "yuengling_son_brewing-yuengling_lager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if beer == nil {
+		t.Fatalf("expected to load the lager")
+	}
+	descTerms, err := distinctiveTerms(beerReader, "desc", "", beer.Description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mostDistinctive int
+	for term, boost := range descTerms {
+		if len(term) < minSimilarTermLength || boost <= 0 || boost > 1 {
+			t.Errorf("unexpected term '%s' with boost %f", term, boost)
+		}
+		if boost == 1 {
+			mostDistinctive++
+		}
+	}
+	if len(descTerms) > 0 && mostDistinctive == 0 {
+		t.Errorf("expected the most distinctive term to have a boost of 1")
+	}
+
+	similarRequest := &SimilarRequest{ID: "yuengling_son_brewing-yuengling_lager", Size: 3}
+	dmi, err := beerReader.Search(context.Background(), similarRequest.BlugeRequest(beer, descTerms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := dmi.Next()
+	if err != nil || next == nil {
+		t.Fatalf("expected a similar beer, got: %v", err)
+	}
+	docID, _, err := matchToIndexable(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the only other lager of the brewery
+	if docID != "yuengling_son_brewing-yuengling_premium_beer" {
+		t.Errorf("expected the premium beer to be most similar, got %s", docID)
+	}
+	for next != nil && err == nil {
+		docID, _, err = matchToIndexable(next)
+		if err == nil && docID == similarRequest.ID {
+			t.Errorf("expected the beer itself to be left out")
+		}
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/blugelabs/bluge"
+)
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// HealthHandler answers as long as the server is running
+type HealthHandler struct{}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	mustEncode(w, &HealthResponse{Status: "ok"})
+}
+
+// ReadyHandler answers 503 Service Unavailable until the initial indexing
+// has finished, so that traffic can be held back from a partial index
+type ReadyHandler struct {
+	status *IndexStatus
+}
+
+func NewReadyHandler(status *IndexStatus) *ReadyHandler {
+	return &ReadyHandler{
+		status: status,
+	}
+}
+
+func (h *ReadyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	progress := h.status.Progress()
+	rv := &HealthResponse{Status: "ready"}
+	switch {
+	case progress.Ready:
+	case progress.Failed:
+		rv.Status = "failed"
+	default:
+		rv.Status = "indexing"
+	}
+	if !progress.Ready {
+		mustEncodeStatus(w, http.StatusServiceUnavailable, rv)
+		return
+	}
+	mustEncode(w, rv)
+}
+
+type IndexInfo struct {
+	Path     string `json:"path"`
+	DocCount uint64 `json:"doc_count"`
+}
+
+type StatusResponse struct {
+	Indexes  map[string]*IndexInfo `json:"indexes"`
+	Indexing *IndexingProgress     `json:"indexing"`
+}
+
+// StatusHandler reports the number of documents in each index, and the
+// progress of indexing
+type StatusHandler struct {
+	beerIndexWriter    *bluge.Writer
+	breweryIndexWriter *bluge.Writer
+	beerIndexPath      string
+	breweryIndexPath   string
+	status             *IndexStatus
+	logger             *log.Logger
+}
+
+func NewStatusHandler(beerIndexWriter, breweryIndexWriter *bluge.Writer, beerIndexPath, breweryIndexPath string,
+	status *IndexStatus, logger *log.Logger) *StatusHandler {
+	return &StatusHandler{
+		beerIndexWriter:    beerIndexWriter,
+		breweryIndexWriter: breweryIndexWriter,
+		beerIndexPath:      beerIndexPath,
+		breweryIndexPath:   breweryIndexPath,
+		status:             status,
+		logger:             logger,
+	}
+}
+
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	beerReader, breweryReader, err := openReaders(h.beerIndexWriter, h.breweryIndexWriter)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error opening readers: %v", err), 500, h.logger)
+		return
+	}
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
+
+	beerCount, err := beerReader.Count()
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error counting beers: %v", err), 500, h.logger)
+		return
+	}
+	breweryCount, err := breweryReader.Count()
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error counting breweries: %v", err), 500, h.logger)
+		return
+	}
+
+	mustEncode(w, &StatusResponse{
+		Indexes: map[string]*IndexInfo{
+			beerIndexName:    {Path: h.beerIndexPath, DocCount: beerCount},
+			breweryIndexName: {Path: h.breweryIndexPath, DocCount: breweryCount},
+		},
+		Indexing: h.status.Progress(),
+	})
+}
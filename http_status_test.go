@@ -0,0 +1,109 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		update       func(s *IndexStatus)
+		expectCode   int
+		expectStatus string
+	}{
+		{
+			name:         "not started",
+			update:       func(s *IndexStatus) {},
+			expectCode:   http.StatusServiceUnavailable,
+			expectStatus: "indexing",
+		},
+		{
+			name: "indexing",
+			update: func(s *IndexStatus) {
+				s.Start(10)
+				s.FileProcessed()
+			},
+			expectCode:   http.StatusServiceUnavailable,
+			expectStatus: "indexing",
+		},
+		{
+			name: "finished",
+			update: func(s *IndexStatus) {
+				s.Start(10)
+				s.Finish(nil)
+			},
+			expectCode:   http.StatusOK,
+			expectStatus: "ready",
+		},
+		{
+			name: "failed",
+			update: func(s *IndexStatus) {
+				s.Start(10)
+				s.Finish(fmt.Errorf("error parsing JSON"))
+			},
+			expectCode:   http.StatusServiceUnavailable,
+			expectStatus: "failed",
+		},
+		{
+			name:         "existing index",
+			update:       func(s *IndexStatus) { s.SetReady() },
+			expectCode:   http.StatusOK,
+			expectStatus: "ready",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			status := &IndexStatus{}
+			test.update(status)
+			w := httptest.NewRecorder()
+			NewReadyHandler(status).ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			if w.Code != test.expectCode {
+				t.Errorf("expected code %d, got %d", test.expectCode, w.Code)
+			}
+			if got := w.Result().Header.Get("Content-type"); got != "application/json" {
+				t.Errorf("expected content type application/json, got %s", got)
+			}
+			if got := w.Result().Header.Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("expected cache control no-cache, got %s", got)
+			}
+			expectBody := fmt.Sprintf(`{"status":"%s"}`, test.expectStatus)
+			if got := strings.TrimSpace(w.Body.String()); got != expectBody {
+				t.Errorf("expected body %s, got %s", expectBody, got)
+			}
+		})
+	}
+}
+
+func TestIndexStatusErrors(t *testing.T) {
+	status := &IndexStatus{}
+	for i := 0; i < maxStatusErrors+5; i++ {
+		status.Error(fmt.Errorf("error %d", i))
+	}
+	progress := status.Progress()
+	if progress.ErrorCount != maxStatusErrors+5 {
+		t.Errorf("expected %d errors counted, got %d", maxStatusErrors+5, progress.ErrorCount)
+	}
+	if len(progress.Errors) != maxStatusErrors || progress.Errors[0] != "error 5" {
+		t.Errorf("expected the last %d errors, got: %v", maxStatusErrors, progress.Errors)
+	}
+}
@@ -0,0 +1,209 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+)
+
+const defaultSuggestions = 5
+const maxSuggestions = 20
+
+type SuggestRequest struct {
+	Query   string    `json:"query"`
+	Size    int       `json:"size"`
+	Filters []*Filter `json:"filters"`
+}
+
+// ParseSuggestRequest builds a SuggestRequest from the URL parameters
+// q, size and filter (name:value, may be repeated)
+func ParseSuggestRequest(params url.Values) (*SuggestRequest, error) {
+	rv := &SuggestRequest{
+		Query: params.Get("q"),
+	}
+	if sizeStr := params.Get("size"); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing size '%s': %v", sizeStr, err)
+		}
+		rv.Size = size
+	}
+	for _, filterStr := range params["filter"] {
+		filter, err := ParseFilter(filterStr)
+		if err != nil {
+			return nil, err
+		}
+		rv.Filters = append(rv.Filters, filter)
+	}
+	return rv, nil
+}
+
+func (r *SuggestRequest) BlugeRequest() (bluge.SearchRequest, error) {
+	if strings.TrimSpace(r.Query) == "" {
+		return nil, fmt.Errorf("suggest query is required")
+	}
+	if r.Size < 1 {
+		r.Size = defaultSuggestions
+	}
+	if r.Size > maxSuggestions {
+		r.Size = maxSuggestions
+	}
+	for _, filter := range r.Filters {
+		err := filter.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// every word typed must prefix a word in the name, whole word
+	// matches on the name are preferred
+	prefixes := bluge.NewMatchQuery(r.Query).
+		SetField(nameSuggestField).
+		SetAnalyzer(suggestQueryAnalyzer).
+		SetOperator(bluge.MatchQueryOperatorAnd)
+	q := bluge.NewBooleanQuery().
+		AddMust(prefixes).
+		AddShould(bluge.NewMatchQuery(r.Query).SetField("name").SetAnalyzer(textAnalysis.Analyzer("name", "")))
+
+	filters := (&SearchRequest{Filters: r.Filters}).buildFilterClauses()
+	if len(filters) > 0 {
+		q.AddMust(filters...)
+	}
+
+	return bluge.NewTopNSearch(r.Size, q), nil
+}
+
+type Suggestion struct {
+	ID    string  `json:"id"`
+	Type  string  `json:"type"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+type SuggestResponse struct {
+	Query       string        `json:"query"`
+	Suggestions []*Suggestion `json:"suggestions"`
+	Duration    string        `json:"duration"`
+}
+
+// SuggestHandler can handle search-as-you-type requests sent over HTTP
+type SuggestHandler struct {
+	beerIndexWriter    *bluge.Writer
+	breweryIndexWriter *bluge.Writer
+	logger             *log.Logger
+}
+
+func NewSuggestHandler(beerIndexWriter, breweryIndexWriter *bluge.Writer, logger *log.Logger) *SuggestHandler {
+	return &SuggestHandler{
+		beerIndexWriter:    beerIndexWriter,
+		breweryIndexWriter: breweryIndexWriter,
+		logger:             logger,
+	}
+}
+
+func (h *SuggestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	startTime := time.Now()
+
+	var suggestRequest *SuggestRequest
+	var err error
+	if req.Method == http.MethodGet {
+		suggestRequest, err = ParseSuggestRequest(req.URL.Query())
+		if err != nil {
+			showError(w, req, err.Error(), 400, h.logger)
+			return
+		}
+	} else {
+		var requestBody []byte
+		requestBody, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error reading request body: %v", err), 400, h.logger)
+			return
+		}
+		suggestRequest = &SuggestRequest{}
+		err = json.Unmarshal(requestBody, suggestRequest)
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error parsing request: %v", err), 400, h.logger)
+			return
+		}
+	}
+
+	blugeRequest, err := suggestRequest.BlugeRequest()
+	if err != nil {
+		showError(w, req, err.Error(), 400, h.logger)
+		return
+	}
+
+	beerReader, breweryReader, err := openReaders(h.beerIndexWriter, h.breweryIndexWriter)
+	if err != nil {
+		showError(w, req, err.Error(), 400, h.logger)
+		return
+	}
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
+
+	blugeResponse, err := bluge.MultiSearch(context.Background(), blugeRequest, beerReader, breweryReader)
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error executing query: %v", err), 500, h.logger)
+		return
+	}
+
+	suggestResponse := &SuggestResponse{
+		Query: suggestRequest.Query,
+	}
+	next, err := blugeResponse.Next()
+	for err == nil && next != nil {
+		suggestion := &Suggestion{
+			Score: next.Score,
+		}
+		err = next.VisitStoredFields(func(field string, value []byte) bool {
+			switch field {
+			case "_id":
+				suggestion.ID = string(value)
+			case "_type":
+				suggestion.Type = string(value)
+			case nameSuggestField:
+				suggestion.Name = string(value)
+			}
+			return true
+		})
+		if err != nil {
+			showError(w, req, fmt.Sprintf("error visiting stored fields: %v", err), 500, h.logger)
+			return
+		}
+		suggestResponse.Suggestions = append(suggestResponse.Suggestions, suggestion)
+
+		next, err = blugeResponse.Next()
+	}
+	if err != nil {
+		showError(w, req, fmt.Sprintf("error executing query: %v", err), 500, h.logger)
+		return
+	}
+	suggestResponse.Duration = time.Since(startTime).String()
+
+	mustEncode(w, suggestResponse)
+}
@@ -0,0 +1,175 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestSuggest(t *testing.T) {
+	// the longest word is longer than suggestMaxGram
+	const longName = "Donaudampfschifffahrtsgesellschaft Lager"
+	yuengling := func(filename string) bool {
+		return strings.HasPrefix(filename, "yuengling_son_brewing")
+	}
+	beerReader := openTestIndex(t, typeBeer, yuengling)
+	breweryReader := openTestIndex(t, typeBrewery, yuengling)
+	longReader := openNamesIndex(t, longName)
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+		_ = longReader.Close()
+	}()
+
+	tests := []struct {
+		name   string
+		in     *SuggestRequest
+		expect []string
+	}{
+		{
+			name: "partial prefix",
+			in:   &SuggestRequest{Query: "yuen", Size: maxSuggestions},
+			expect: []string{"Yuengling & Son Brewing", "Yuengling Black and Tan", "Yuengling Bock Beer",
+				"Yuengling Lager", "Yuengling Lager Light", "Yuengling Porter", "Yuengling Premium Beer",
+				"Yuengling Premium Light"},
+		},
+		{
+			name:   "every word",
+			in:     &SuggestRequest{Query: "Yuengling lag", Size: maxSuggestions},
+			expect: []string{"Yuengling Lager", "Yuengling Lager Light"},
+		},
+		{
+			name: "filtered",
+			in: &SuggestRequest{Query: "yuen", Size: maxSuggestions,
+				Filters: []*Filter{{Name: "style-facet", Value: "American-Style Lager"}}},
+			expect: []string{"Yuengling Lager", "Yuengling Premium Beer"},
+		},
+		{
+			name:   "long word prefix",
+			in:     &SuggestRequest{Query: "donaudampfschiff"},
+			expect: []string{longName},
+		},
+		{
+			name:   "long word",
+			in:     &SuggestRequest{Query: "donaudampfschifffahrtsgesellschaft"},
+			expect: []string{longName},
+		},
+		{
+			name: "no match",
+			in:   &SuggestRequest{Query: "yuengx"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			blugeRequest, err := test.in.BlugeRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := suggestNames(t, blugeRequest, beerReader, breweryReader, longReader)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected suggestions: %q, got: %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestSuggestWholeWordFirst(t *testing.T) {
+	// the shorter name would score higher on the prefix alone, the whole
+	// word is stemmed in the name field
+	reader := openNamesIndex(t, "Yuenglingfest", "Yuengling Lager Light Reserve")
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	blugeRequest, err := (&SuggestRequest{Query: "yuengling"}).BlugeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := suggestNames(t, blugeRequest, reader)
+	expect := []string{"Yuengling Lager Light Reserve", "Yuenglingfest"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected suggestions: %q, got: %q", expect, got)
+	}
+}
+
+// suggestNames returns the names of the suggestions in order
+func suggestNames(t *testing.T, blugeRequest bluge.SearchRequest, readers ...*bluge.Reader) []string {
+	dmi, err := bluge.MultiSearch(context.Background(), blugeRequest, readers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rv []string
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		err = next.VisitStoredFields(func(field string, value []byte) bool {
+			if field == nameSuggestField {
+				rv = append(rv, string(value))
+			}
+			return true
+		})
+		if err == nil {
+			next, err = dmi.Next()
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rv
+}
+
+// openNamesIndex indexes beers with the names
+func openNamesIndex(t *testing.T, names ...string) *bluge.Reader {
+	indexWriter, err := bluge.OpenWriter(bluge.InMemoryOnlyConfig().
+		WithVirtualField(bluge.NewKeywordField("_type", typeBeer).StoreValue()))
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	batch := bluge.NewBatch()
+	for i, name := range names {
+		source, err := json.Marshal(map[string]string{"type": typeBeer, "name": name, "brewery_id": "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, _, err := unmarshalByType(typeBeer, fmt.Sprintf("test-%d", i), source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := obj.Document(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch.Update(doc.ID(), doc)
+	}
+	err = indexWriter.Batch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return indexReader
+}
@@ -26,12 +26,16 @@ func staticFileRouter() *mux.Router {
 
 func showError(w http.ResponseWriter, r *http.Request,
 	msg string, code int, logger *log.Logger) {
-	logger.Printf("Reporting error %v/%v", code, msg)
+	if requestLogFor(r) != nil {
+		// reported in the log entry of the request
+		addLogFields(r, LogFields{"error": msg})
+	} else {
+		logger.Printf("Reporting error %v/%v", code, msg)
+	}
 	http.Error(w, msg, code)
 }
 
 func mustEncode(w io.Writer, i interface{}) {
-	log.Printf("%#v", i)
 	if headered, ok := w.(http.ResponseWriter); ok {
 		headered.Header().Set("Cache-Control", "no-cache")
 		headered.Header().Set("Content-type", "application/json")
@@ -42,3 +46,12 @@ func mustEncode(w io.Writer, i interface{}) {
 		panic(err)
 	}
 }
+
+// mustEncodeStatus encodes i with the status code, the headers are set
+// before the status code is written
+func mustEncodeStatus(w http.ResponseWriter, code int, i interface{}) {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(code)
+	mustEncode(w, i)
+}
@@ -0,0 +1,147 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses one of debug, info, warn or error
+func ParseLogLevel(in string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(in, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s', expected one of: %s", in, strings.Join(logLevelNames, ", "))
+}
+
+// LogFields are the fields of a log entry, besides its time, level and
+// message
+type LogFields map[string]interface{}
+
+// appLog logs the requests and events of the server
+var appLog = NewJSONLogger(os.Stderr, LevelInfo)
+
+// queryLog logs every search to its own file, when configured
+var queryLog *JSONLogger
+
+// JSONLogger writes log entries as lines of JSON, skipping entries below
+// its level
+type JSONLogger struct {
+	m     sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+func NewJSONLogger(w io.Writer, level LogLevel) *JSONLogger {
+	return &JSONLogger{
+		w:     w,
+		level: level,
+	}
+}
+
+// SetLevel changes the level entries must be at to be written
+func (l *JSONLogger) SetLevel(level LogLevel) {
+	l.m.Lock()
+	l.level = level
+	l.m.Unlock()
+}
+
+func (l *JSONLogger) Enabled(level LogLevel) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return level >= l.level
+}
+
+// Log writes the entry, when the logger is at its level or below
+func (l *JSONLogger) Log(level LogLevel, msg string, fields LogFields) {
+	if !l.Enabled(level) {
+		return
+	}
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{
+			"time":  entry["time"].(string),
+			"level": LevelError.String(),
+			"msg":   fmt.Sprintf("error encoding log entry '%s': %v", msg, err),
+		})
+	}
+	line = append(line, '\n')
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	_, _ = l.w.Write(line)
+}
+
+func (l *JSONLogger) Debug(msg string, fields LogFields) {
+	l.Log(LevelDebug, msg, fields)
+}
+
+func (l *JSONLogger) Info(msg string, fields LogFields) {
+	l.Log(LevelInfo, msg, fields)
+}
+
+func (l *JSONLogger) Warn(msg string, fields LogFields) {
+	l.Log(LevelWarn, msg, fields)
+}
+
+func (l *JSONLogger) Error(msg string, fields LogFields) {
+	l.Log(LevelError, msg, fields)
+}
+
+// Write logs each line written by a standard library logger as an entry,
+// at error level for the lines reporting errors, otherwise at info level
+func (l *JSONLogger) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		level := LevelInfo
+		if strings.HasPrefix(strings.ToLower(line), "error") {
+			level = LevelError
+		}
+		l.Log(level, line, nil)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,163 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  string
+		log    func(l *JSONLogger)
+		expect []map[string]interface{}
+	}{
+		{
+			name:  "below level",
+			level: "warn",
+			log: func(l *JSONLogger) {
+				l.Info("request", LogFields{"status": 200})
+				l.Warn("request", LogFields{"status": 404})
+			},
+			expect: []map[string]interface{}{
+				{"level": "warn", "msg": "request", "status": float64(404)},
+			},
+		},
+		{
+			name:  "standard logger",
+			level: "DEBUG",
+			log: func(l *JSONLogger) {
+				std := log.New(l, "", 0)
+				std.Printf("Indexing...")
+				std.Printf("error indexing data: %v", "missing")
+			},
+			expect: []map[string]interface{}{
+				{"level": "info", "msg": "Indexing..."},
+				{"level": "error", "msg": "error indexing data: missing"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			level, err := ParseLogLevel(test.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			test.log(NewJSONLogger(&buf, level))
+			got := decodeLogEntries(t, &buf)
+			if len(got) != len(test.expect) {
+				t.Fatalf("expected %d entries, got: %v", len(test.expect), got)
+			}
+			for i, entry := range got {
+				if _, ok := entry["time"]; !ok {
+					t.Errorf("expected entry %d to have a time", i)
+				}
+				delete(entry, "time")
+				if !jsonEqual(entry, test.expect[i]) {
+					t.Errorf("expected entry %d: %v, got: %v", i, test.expect[i], entry)
+				}
+			}
+		})
+	}
+
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Errorf("expected error parsing unknown level")
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(prev *JSONLogger) {
+		appLog = prev
+	}(appLog)
+	appLog = NewJSONLogger(&buf, LevelInfo)
+
+	handler := assignRequestIDs(logRequests(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		addLogFields(req, LogFields{"query": "stout"})
+		showError(w, req, "no stouts", 404, nil)
+	})))
+
+	tests := []struct {
+		name     string
+		sentID   string
+		expectID string
+	}{
+		{name: "client id", sentID: "abc-123", expectID: "abc-123"},
+		{name: "generated id"},
+		{name: "unsafe id", sentID: "abc\"123"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest("GET", "/api/search?q=stout", nil)
+			if test.sentID != "" {
+				req.Header.Set(requestIDHeader, test.sentID)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			id := w.Header().Get(requestIDHeader)
+			if test.expectID != "" && id != test.expectID {
+				t.Errorf("expected request id '%s', got '%s'", test.expectID, id)
+			}
+			if !requestIDRegexp.MatchString(id) {
+				t.Errorf("expected a safe request id, got '%s'", id)
+			}
+			entries := decodeLogEntries(t, &buf)
+			if len(entries) != 1 {
+				t.Fatalf("expected one entry, got: %v", entries)
+			}
+			entry := entries[0]
+			if entry["request_id"] != id || entry["level"] != "warn" || entry["status"] != float64(404) ||
+				entry["query"] != "stout" || entry["error"] != "no stouts" {
+				t.Errorf("unexpected entry: %v", entry)
+			}
+		})
+	}
+}
+
+func decodeLogEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var rv []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("error decoding log entry '%s': %v", line, err)
+		}
+		rv = append(rv, entry)
+	}
+	return rv
+}
+
+func jsonEqual(a, b map[string]interface{}) bool {
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	return bytes.Equal(aj, bj)
+}
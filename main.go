@@ -28,6 +28,7 @@ import (
 	"github.com/blugelabs/bluge/search/aggregations"
 
 	"github.com/blugelabs/bluge"
+	"github.com/gorilla/mux"
 )
 
 var batchSize = flag.Int("batchSize", 1000, "batch size for indexing")
@@ -37,6 +38,21 @@ var beerIndexPath = flag.String("beerIndexPath", "beers.bluge", "beer index path
 var breweryIndexPath = flag.String("breweryIndexPath", "breweries.bluge", "brewery index path")
 var staticPath = flag.String("static", "static/", "Path to the static content")
 var doIndex = flag.Bool("index", true, "index or reindex the data")
+var doWatch = flag.Bool("watch", false, "watch the json directory and apply changes to the index")
+var watchInterval = flag.Duration("watchInterval", 5*time.Second, "how often to check the json directory for changes")
+var fieldAnalyzers = flag.String("analyzers", "",
+	"field=analyzer pairs separated by commas, replacing the default analyzers, one of: standard, folding, en, de, fr")
+var languageAnalysis = flag.Bool("languageAnalysis", false,
+	"analyze the name and description in German or French by the brewery country, requires reindexing")
+var synonymsPath = flag.String("synonyms", "synonyms.txt", "synonyms file, reloaded when it changes")
+var synonymsInterval = flag.Duration("synonymsInterval", 5*time.Second, "how often to check the synonyms file for changes")
+
+var logLevel = flag.String("logLevel", "info", "lowest level of the entries logged, one of: debug, info, warn, error")
+var queryLogPath = flag.String("queryLog", "", "file to append a JSON entry for every search to")
+
+var analyticsPath = flag.String("analytics", "",
+	"file searches and clicks are appended to for analytics, analytics are disabled unless set")
+var analyticsRetention = flag.Duration("analyticsRetention", 30*24*time.Hour, "how long searches and clicks are reported on")
 
 var doTestSearch = flag.Bool("testSearch", false, "test search from another process")
 var backupBeersTo = flag.String("backupBeersTo", "", "path to backup the beers index to")
@@ -44,14 +60,20 @@ var backupBeersTo = flag.String("backupBeersTo", "", "path to backup the beers i
 func main() {
 	flag.Parse()
 
-	logger := log.New(os.Stderr, "beer-search", log.LstdFlags)
+	err := configureLogging()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := log.New(appLog, "", 0)
 
 	fieldTypeBeer := bluge.NewKeywordField("_type", "beer").StoreValue().Aggregatable()
 	beerCfg := bluge.DefaultConfig(*beerIndexPath).
-		WithVirtualField(fieldTypeBeer)
+		WithVirtualField(fieldTypeBeer).
+		WithSearchStartFunc(observeSearchStart(beerIndexName))
 	fieldTypeBrewery := bluge.NewKeywordField("_type", "brewery").StoreValue().Aggregatable()
 	breweryCfg := bluge.DefaultConfig(*breweryIndexPath).
-		WithVirtualField(fieldTypeBrewery)
+		WithVirtualField(fieldTypeBrewery).
+		WithSearchStartFunc(observeSearchStart(breweryIndexName))
 
 	if *backupBeersTo != "" {
 		indexReader, err := bluge.OpenReader(beerCfg)
@@ -111,20 +133,45 @@ func main() {
 		log.Fatalf("error opening breweries index '%s': %v", *breweryIndexPath, err)
 	}
 
-	if *doIndex {
-		go func() {
-			err := indexData(beerIndexWriter, breweryIndexWriter)
-			if err != nil {
-				log.Fatalf("error indexing data: %v", err)
-			}
-		}()
+	err = configureTextAnalysis()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = synonyms.Load(*synonymsPath)
+	if err != nil {
+		log.Fatalf("error loading synonyms: %v", err)
 	}
+	log.Printf("Loaded %d synonyms from '%s'", synonyms.Len(), *synonymsPath)
+	go synonyms.Watch(*synonymsInterval, nil)
+
+	rollup := NewBreweryRollup(beerIndexWriter, breweryIndexWriter)
+	startIndexing(beerIndexWriter, breweryIndexWriter, rollup)
+
 	// create a router to serve static files
 	router := staticFileRouter()
+	router.Use(assignRequestIDs, logRequests, instrumentRequests)
 
 	// add the API
-	searchHandler := NewSearchHandler(beerIndexWriter, breweryIndexWriter, logger)
-	router.Handle("/api/search", searchHandler).Methods("POST")
+	var analytics *AnalyticsStore
+	if *analyticsPath != "" {
+		analytics, err = OpenAnalyticsStore(*analyticsPath, *analyticsRetention)
+		if err != nil {
+			log.Fatal(err)
+		}
+		router.Handle("/api/analytics", NewAnalyticsHandler(analytics, logger)).Methods("GET")
+		router.Handle("/api/analytics/clicks", NewClickHandler(analytics, logger)).Methods("POST")
+	}
+	searchHandler := NewSearchHandler(beerIndexWriter, breweryIndexWriter, analytics, logger)
+	router.Handle("/api/search", searchHandler).Methods("GET", "POST")
+	router.Handle("/api/suggest", NewSuggestHandler(beerIndexWriter, breweryIndexWriter, logger)).Methods("GET", "POST")
+	router.Handle("/api/beers/{id}/similar", NewSimilarHandler(beerIndexWriter, breweryIndexWriter, logger)).
+		Methods("GET")
+	router.Handle("/api/beers/{id}", NewDocumentHandler(typeBeer, beerIndexWriter, breweryIndexWriter, rollup, logger)).
+		Methods("GET", "PUT", "DELETE")
+	router.Handle("/api/breweries/{id}", NewDocumentHandler(typeBrewery, beerIndexWriter, breweryIndexWriter, rollup, logger)).
+		Methods("GET", "PUT", "DELETE")
+
+	handleOperations(router, beerIndexWriter, breweryIndexWriter, logger)
 
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir(*staticPath)))
 
@@ -134,6 +181,85 @@ func main() {
 	log.Fatal(http.ListenAndServe(*bindAddr, nil))
 }
 
+// startIndexing indexes the data and then watches it for changes in the
+// background, as configured by the flags
+func startIndexing(beerIndexWriter, breweryIndexWriter *bluge.Writer, rollup *BreweryRollup) {
+	if !*doIndex {
+		indexStatus.SetReady()
+	}
+	if !*doIndex && !*doWatch {
+		return
+	}
+	go func() {
+		var watcher *DirWatcher
+		if *doWatch {
+			// files changing while the data is indexed are picked up by the
+			// first poll
+			watcher = NewDirWatcher(*jsonDir, beerIndexWriter, breweryIndexWriter, rollup)
+			err := watcher.Snapshot()
+			if err != nil {
+				log.Fatalf("error watching '%s': %v", *jsonDir, err)
+			}
+		}
+		if *doIndex {
+			err := indexData(beerIndexWriter, breweryIndexWriter)
+			indexStatus.Finish(err)
+			if err != nil {
+				// the server keeps running, reporting the failure as not ready
+				log.Printf("error indexing data: %v", err)
+				return
+			}
+		}
+		if watcher != nil {
+			err := watcher.Watch(*watchInterval, nil)
+			if err != nil {
+				log.Fatalf("error watching '%s': %v", *jsonDir, err)
+			}
+		}
+	}()
+}
+
+// handleOperations adds the status, metrics, health and readiness endpoints
+func handleOperations(router *mux.Router, beerIndexWriter, breweryIndexWriter *bluge.Writer, logger *log.Logger) {
+	router.Handle("/api/status", NewStatusHandler(beerIndexWriter, breweryIndexWriter, *beerIndexPath, *breweryIndexPath,
+		indexStatus, logger)).Methods("GET")
+	router.Handle("/metrics", NewMetricsHandler(beerIndexWriter, breweryIndexWriter, *beerIndexPath, *breweryIndexPath,
+		logger)).Methods("GET")
+	router.Handle("/healthz", &HealthHandler{}).Methods("GET")
+	router.Handle("/readyz", NewReadyHandler(indexStatus)).Methods("GET")
+}
+
+// configureLogging logs JSON entries at the level of the flags, including
+// the entries of the standard logger, and opens the query log
+func configureLogging() error {
+	level, err := ParseLogLevel(*logLevel)
+	if err != nil {
+		return err
+	}
+	appLog.SetLevel(level)
+	log.SetFlags(0)
+	log.SetOutput(appLog)
+
+	if *queryLogPath != "" {
+		f, err := os.OpenFile(*queryLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening query log '%s': %v", *queryLogPath, err)
+		}
+		queryLog = NewJSONLogger(f, LevelInfo)
+	}
+	return nil
+}
+
+// configureTextAnalysis analyzes text as configured by the flags
+func configureTextAnalysis() error {
+	fields, err := ParseFieldAnalyzers(*fieldAnalyzers)
+	if err != nil {
+		return fmt.Errorf("error parsing analyzers: %v", err)
+	}
+	textAnalysis = NewTextAnalysis(fields, *languageAnalysis)
+	return nil
+}
+
 func parseAndBuildDoc(dir, filename string) (Indexable, *bluge.Document, error) {
 	obj, jsonBytes, err := parseJSONPath(dir, filename)
 	if err != nil {
@@ -153,66 +279,156 @@ func indexData(beerIndexWriter, breweryIndexWriter *bluge.Writer) error {
 	if err != nil {
 		return err
 	}
+	indexStatus.Start(len(dirEntries))
 
 	var beerIndexedCount, breweryIndexedCount int
-	var beers, breweries []*bluge.Document
+	// beers are mapped once the breweries are read, to be analyzed in the
+	// language of their brewery, and breweries are indexed once all of
+	// their beers have been rolled up
+	var pendingBeers []*pendingBeer
+	var pendingBreweries []*pendingBrewery
+	rollups := make(breweryRollups)
 	for _, dirEntry := range dirEntries {
 		var obj Indexable
-		var doc *bluge.Document
-		obj, doc, err = parseAndBuildDoc(*jsonDir, dirEntry.Name())
+		var jsonBytes []byte
+		obj, jsonBytes, err = parseJSONPath(*jsonDir, dirEntry.Name())
 		if err != nil {
-			return err
+			return fmt.Errorf("error parsing JSON '%s': %w", dirEntry.Name(), err)
 		}
-		switch obj.(type) {
+		switch o := obj.(type) {
 		case *Beer:
-			beers = append(beers, doc)
+			pendingBeers = append(pendingBeers, &pendingBeer{beer: o, source: jsonBytes})
+			rollups.Add(o)
 		case *Brewery:
-			breweries = append(breweries, doc)
+			pendingBreweries = append(pendingBreweries, &pendingBrewery{brewery: o, source: jsonBytes})
 		}
+		indexStatus.FileProcessed()
+	}
+
+	beerIndexedCount, err = indexBeers(beerIndexWriter, pendingBeers, breweryLanguages(pendingBreweries))
+	if err != nil {
+		return err
+	}
+	breweryIndexedCount, err = indexBreweries(breweryIndexWriter, pendingBreweries, rollups)
+	if err != nil {
+		return err
+	}
+
+	indexTime := time.Since(startTime)
+	timePerDoc := float64(indexTime) / float64(beerIndexedCount+breweryIndexedCount)
+	log.Printf("Indexed %d documents, in %s (average %.2fms/doc)", beerIndexedCount+breweryIndexedCount,
+		indexTime, timePerDoc/float64(time.Millisecond))
+	indexingThroughput.Set(float64(beerIndexedCount+breweryIndexedCount) / (float64(indexTime) / float64(time.Millisecond)))
+	return nil
+}
+
+// breweryLanguages maps the IDs of the breweries to their language, when
+// language analysis is enabled, so that beers are analyzed in the language
+// of their brewery
+func breweryLanguages(pendingBreweries []*pendingBrewery) map[string]string {
+	rv := make(map[string]string)
+	if !textAnalysis.languages {
+		return rv
+	}
+	for _, pending := range pendingBreweries {
+		if language := languageForCountry(pending.brewery.Country); language != "" {
+			rv[pending.brewery.ID] = language
+		}
+	}
+	return rv
+}
+
+// indexBeers indexes the beers in the language of their brewery, returning
+// the number indexed
+func indexBeers(beerIndexWriter *bluge.Writer, pendingBeers []*pendingBeer,
+	languages map[string]string) (count int, err error) {
+	var beers []*bluge.Document
+	for _, pending := range pendingBeers {
+		pending.beer.Language = languages[pending.beer.BreweryID]
+		var doc *bluge.Document
+		doc, err = pending.beer.Document(pending.source)
+		if err != nil {
+			return count, fmt.Errorf("error mapping object: %w", err)
+		}
+		beers = append(beers, doc)
 
 		if len(beers) > *batchSize {
-			err = indexBatch(beerIndexWriter, beers)
+			err = indexBatch(beerIndexWriter, beerIndexName, beers)
 			if err != nil {
-				return fmt.Errorf("error executing beer batch: %w", err)
+				return count, fmt.Errorf("error executing beer batch: %w", err)
 			}
-			beerIndexedCount += len(beers)
+			count += len(beers)
 			beers = beers[:0]
 		}
+	}
+	if len(beers) > 0 {
+		err = indexBatch(beerIndexWriter, beerIndexName, beers)
+		if err != nil {
+			return count, fmt.Errorf("error executing beer batch: %w", err)
+		}
+		count += len(beers)
+	}
+	return count, nil
+}
+
+// indexBreweries indexes the breweries along with the stats rolled up from
+// their beers, returning the number indexed
+func indexBreweries(breweryIndexWriter *bluge.Writer, pendingBreweries []*pendingBrewery,
+	rollups breweryRollups) (count int, err error) {
+	var breweries []*bluge.Document
+	for _, pending := range pendingBreweries {
+		pending.brewery.Stats = rollups.Stats(pending.brewery.ID)
+		var doc *bluge.Document
+		doc, err = pending.brewery.Document(pending.source)
+		if err != nil {
+			return count, fmt.Errorf("error mapping object: %w", err)
+		}
+		breweries = append(breweries, doc)
+
 		if len(breweries) > *batchSize {
-			err = indexBatch(breweryIndexWriter, breweries)
+			err = indexBatch(breweryIndexWriter, breweryIndexName, breweries)
 			if err != nil {
-				return fmt.Errorf("error executing brewery batch: %w", err)
+				return count, fmt.Errorf("error executing brewery batch: %w", err)
 			}
-			breweryIndexedCount += len(breweries)
+			count += len(breweries)
 			breweries = breweries[:0]
 		}
 	}
-	if len(beers) > 0 {
-		err = indexBatch(beerIndexWriter, beers)
-		if err != nil {
-			return fmt.Errorf("error executing beer batch: %w", err)
-		}
-		beerIndexedCount += len(beers)
-	}
 	if len(breweries) > 0 {
-		err = indexBatch(breweryIndexWriter, breweries)
+		err = indexBatch(breweryIndexWriter, breweryIndexName, breweries)
 		if err != nil {
-			return fmt.Errorf("error executing brewery batch: %w", err)
+			return count, fmt.Errorf("error executing brewery batch: %w", err)
 		}
-		breweryIndexedCount += len(breweries)
+		count += len(breweries)
 	}
+	return count, nil
+}
 
-	indexTime := time.Since(startTime)
-	timePerDoc := float64(indexTime) / float64(beerIndexedCount+breweryIndexedCount)
-	log.Printf("Indexed %d documents, in %s (average %.2fms/doc)", beerIndexedCount+breweryIndexedCount,
-		indexTime, timePerDoc/float64(time.Millisecond))
-	return nil
+// pendingBeer is a beer waiting for the language of its brewery
+type pendingBeer struct {
+	beer   *Beer
+	source []byte
+}
+
+// pendingBrewery is a brewery waiting for the stats of its beers
+type pendingBrewery struct {
+	brewery *Brewery
+	source  []byte
 }
 
-func indexBatch(indexWriter *bluge.Writer, docs []*bluge.Document) error {
+// indexBatch indexes the documents in the named index in one batch
+func indexBatch(indexWriter *bluge.Writer, index string, docs []*bluge.Document) error {
+	startTime := time.Now()
 	batch := bluge.NewBatch()
 	for _, doc := range docs {
 		batch.Update(doc.ID(), doc)
 	}
-	return indexWriter.Batch(batch)
+	err := indexWriter.Batch(batch)
+	if err != nil {
+		return err
+	}
+	indexBatchDuration.Observe(time.Since(startTime).Seconds(), index)
+	indexedDocuments.Add(float64(len(docs)), index)
+	indexStatus.Indexed()
+	return nil
 }
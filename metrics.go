@@ -0,0 +1,250 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// latencyBuckets are the upper bounds of request durations, in seconds
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// hitBuckets are the upper bounds of the number of hits of searches
+var hitBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 5000}
+
+// batchBuckets are the upper bounds of batch durations, in seconds
+var batchBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var metrics = NewMetricsRegistry()
+
+var (
+	httpRequests = metrics.NewCounter("beer_search_http_requests_total",
+		"HTTP requests by endpoint and status code.", "endpoint", "code")
+	httpRequestDuration = metrics.NewHistogram("beer_search_http_request_duration_seconds",
+		"HTTP request latency by endpoint and status code.", latencyBuckets, "endpoint", "code")
+	searches = metrics.NewCounter("beer_search_searches_total",
+		"Searches executed.")
+	zeroResultSearches = metrics.NewCounter("beer_search_zero_result_searches_total",
+		"Searches finding no hits, the zero-result rate is its rate over the rate of beer_search_searches_total.")
+	searchHitCounts = metrics.NewHistogram("beer_search_search_hits",
+		"Hits found by searches.", hitBuckets)
+	indexedDocuments = metrics.NewCounter("beer_search_indexed_documents_total",
+		"Documents indexed in batches by index.", "index")
+	indexBatchDuration = metrics.NewHistogram("beer_search_index_batch_duration_seconds",
+		"Duration of indexing batches by index.", batchBuckets, "index")
+	indexingThroughput = metrics.NewGauge("beer_search_indexing_documents_per_millisecond",
+		"Documents indexed per millisecond by the last indexing of the JSON directory.")
+	indexDocuments = metrics.NewGauge("beer_search_index_documents",
+		"Documents in the index.", "index")
+	indexDiskBytes = metrics.NewGauge("beer_search_index_disk_bytes",
+		"Bytes used on disk by the index.", "index")
+	indexDiskFiles = metrics.NewGauge("beer_search_index_disk_files",
+		"Files on disk of the index.", "index")
+	indexSegments = metrics.NewGauge("beer_search_index_segments",
+		"Segment files on disk of the index.", "index")
+	indexSearches = metrics.NewCounter("beer_search_index_searches_total",
+		"Searches started on the index.", "index")
+	indexSearchMemory = metrics.NewCounter("beer_search_index_search_memory_bytes_total",
+		"Estimated memory needed by the searches started on the index.", "index")
+)
+
+// MetricsRegistry holds the metrics exposed in the Prometheus text format
+type MetricsRegistry struct {
+	m       sync.Mutex
+	metrics []*metric
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// metric is a family of samples of the same name, one per combination of
+// label values
+type metric struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+
+	m      sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// histograms count the observations at most each bucket bound
+	bucketCounts []uint64
+	count        uint64
+}
+
+func (r *MetricsRegistry) register(m *metric) *metric {
+	m.series = make(map[string]*series)
+	if len(m.labelNames) == 0 {
+		// metrics without labels are exposed before they first change
+		m.update(nil, func(*series) {})
+	}
+	r.m.Lock()
+	r.metrics = append(r.metrics, m)
+	r.m.Unlock()
+	return m
+}
+
+// Counter only ever increases
+type Counter struct{ metric *metric }
+
+// Gauge may go up and down
+type Gauge struct{ metric *metric }
+
+// Histogram counts observations in buckets
+type Histogram struct{ metric *metric }
+
+func (r *MetricsRegistry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{r.register(&metric{name: name, help: help, kind: metricCounter, labelNames: labelNames})}
+}
+
+func (r *MetricsRegistry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.register(&metric{name: name, help: help, kind: metricGauge, labelNames: labelNames})}
+}
+
+func (r *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{r.register(&metric{name: name, help: help, kind: metricHistogram, labelNames: labelNames,
+		buckets: buckets})}
+}
+
+// update applies the change to the series of the label values, which must
+// be as many as the metric's label names
+func (m *metric) update(labelValues []string, change func(s *series)) {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", m.name, m.labelNames, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+	m.m.Lock()
+	defer m.m.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == metricHistogram {
+			s.bucketCounts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	change(s)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.metric.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.metric.update(labelValues, func(s *series) {
+		s.value = v
+	})
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.metric.update(labelValues, func(s *series) {
+		for i, bound := range h.metric.buckets {
+			if v <= bound {
+				s.bucketCounts[i]++
+			}
+		}
+		s.value += v
+		s.count++
+	})
+}
+
+// Expose writes the metrics in the Prometheus text exposition format
+func (r *MetricsRegistry) Expose(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	r.m.Lock()
+	registered := append([]*metric(nil), r.metrics...)
+	r.m.Unlock()
+	for _, m := range registered {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (m *metric) write(w *bufio.Writer) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != metricHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labelNames, s.labelValues), formatValue(s.value))
+			continue
+		}
+		bucketNames := append(append([]string(nil), m.labelNames...), "le")
+		bucketValues := append(append([]string(nil), s.labelValues...), "")
+		for i, bound := range m.buckets {
+			bucketValues[len(bucketValues)-1] = formatValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(bucketNames, bucketValues), s.bucketCounts[i])
+		}
+		bucketValues[len(bucketValues)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(bucketNames, bucketValues), s.count)
+		labels := formatLabels(m.labelNames, s.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labels, formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labels, s.count)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelValueReplacer.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
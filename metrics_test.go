@@ -0,0 +1,92 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricsExpose(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *MetricsRegistry)
+		expect string
+	}{
+		{
+			name: "counter without labels",
+			record: func(r *MetricsRegistry) {
+				r.NewCounter("searches_total", "Searches.")
+			},
+			expect: "# HELP searches_total Searches.\n" +
+				"# TYPE searches_total counter\n" +
+				"searches_total 0\n",
+		},
+		{
+			name: "counter with labels",
+			record: func(r *MetricsRegistry) {
+				c := r.NewCounter("requests_total", "Requests.", "endpoint", "code")
+				c.Inc("/api/search", "200")
+				c.Inc("/api/search", "200")
+				c.Inc("/api/beers/{id}", "404")
+			},
+			expect: "# HELP requests_total Requests.\n" +
+				"# TYPE requests_total counter\n" +
+				"requests_total{endpoint=\"/api/beers/{id}\",code=\"404\"} 1\n" +
+				"requests_total{endpoint=\"/api/search\",code=\"200\"} 2\n",
+		},
+		{
+			name: "gauge escaping labels",
+			record: func(r *MetricsRegistry) {
+				r.NewGauge("docs", "Docs.", "index").Set(1.5, `a "quoted"\name`)
+			},
+			expect: "# HELP docs Docs.\n" +
+				"# TYPE docs gauge\n" +
+				"docs{index=\"a \\\"quoted\\\"\\\\name\"} 1.5\n",
+		},
+		{
+			name: "histogram",
+			record: func(r *MetricsRegistry) {
+				h := r.NewHistogram("hits", "Hits.", []float64{0, 10})
+				h.Observe(0)
+				h.Observe(5)
+				h.Observe(50)
+			},
+			expect: "# HELP hits Hits.\n" +
+				"# TYPE hits histogram\n" +
+				"hits_bucket{le=\"0\"} 1\n" +
+				"hits_bucket{le=\"10\"} 2\n" +
+				"hits_bucket{le=\"+Inf\"} 3\n" +
+				"hits_sum 55\n" +
+				"hits_count 3\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			r := NewMetricsRegistry()
+			test.record(r)
+			var buf bytes.Buffer
+			err := r.Expose(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.expect {
+				t.Errorf("expected metrics:\n%s\ngot:\n%s", test.expect, buf.String())
+			}
+		})
+	}
+}
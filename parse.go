@@ -32,16 +32,32 @@ type Indexable interface {
 	Document([]byte) (*bluge.Document, error)
 }
 
+// docIDFromFilename derives the document ID and type from the name of a
+// JSON file, beer files are named <brewery_id>-<beer>.json
+func docIDFromFilename(filename string) (docID, docType string) {
+	docID = filename[:(len(filename) - len(filepath.Ext(filename)))]
+	if strings.Contains(filename, "-") {
+		return docID, typeBeer
+	}
+	return docID, typeBrewery
+}
+
+// breweryIDFromDocID returns the ID of the brewery a document belongs to,
+// which is the ID of a brewery itself
+func breweryIDFromDocID(docID string) string {
+	if dash := strings.Index(docID, "-"); dash >= 0 {
+		return docID[:dash]
+	}
+	return docID
+}
+
 func parseJSONPath(dir, filename string) (Indexable, []byte, error) {
-	docID := filename[:(len(filename) - len(filepath.Ext(filename)))]
+	docID, docType := docIDFromFilename(filename)
 	jsonBytes, err := ioutil.ReadFile(filepath.Join(dir, filename))
 	if err != nil {
 		return nil, nil, fmt.Errorf("error reading file '%s': %v", filename, err)
 	}
-	if strings.Contains(filename, "-") {
-		return unmarshalByType("beer", docID, jsonBytes)
-	}
-	return unmarshalByType("brewery", docID, jsonBytes)
+	return unmarshalByType(docType, docID, jsonBytes)
 }
 
 func unmarshalByType(_type, _id string, _source []byte) (rv Indexable, src []byte, err error) {
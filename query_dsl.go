@@ -0,0 +1,545 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/numeric/geo"
+)
+
+const queryDSLPath = "query_dsl"
+const maxFuzziness = 2
+
+// dslDateFormats are the formats accepted for date range endpoints
+var dslDateFormats = []string{
+	time.RFC3339,
+	rfc3339NoTimezoneNoT,
+	"2006-01-02",
+}
+
+type dslClauseParser func(path string, raw json.RawMessage) (bluge.Query, error)
+
+// dslClauses maps each clause type of the query DSL to its parser, it is
+// populated in init because the bool parser refers back to it
+var dslClauses map[string]dslClauseParser
+
+func init() {
+	dslClauses = map[string]dslClauseParser{
+		"bool":          parseDSLBool,
+		"term":          parseDSLTerm,
+		"match":         parseDSLMatch,
+		"phrase":        parseDSLPhrase,
+		"fuzzy":         parseDSLFuzzy,
+		"numeric_range": parseDSLNumericRange,
+		"date_range":    parseDSLDateRange,
+		"geo_distance":  parseDSLGeoDistance,
+		"prefix":        parseDSLPrefix,
+		"wildcard":      parseDSLWildcard,
+		"match_all":     parseDSLMatchAll,
+	}
+}
+
+// ParseQueryDSL translates a JSON query tree into a bluge.Query.  Every
+// clause is an object with exactly one key naming the clause type, for
+// example:
+//
+//	{"bool": {"must": [{"match": {"field": "name", "match": "pale ale"}}],
+//	          "must_not": [{"numeric_range": {"field": "abv", "min": 8}}]}}
+//
+// Errors identify the malformed clause by its path in the tree, such as
+// query_dsl.bool.must_not[0].numeric_range
+func ParseQueryDSL(raw json.RawMessage) (bluge.Query, error) {
+	return parseDSLClause(queryDSLPath, raw)
+}
+
+func parseDSLClause(path string, raw json.RawMessage) (bluge.Query, error) {
+	var clause map[string]json.RawMessage
+	err := json.Unmarshal(raw, &clause)
+	if err != nil || clause == nil {
+		return nil, fmt.Errorf("%s: clause must be an object with one of the keys: %s",
+			path, strings.Join(dslClauseNames(), ", "))
+	}
+	if len(clause) != 1 {
+		return nil, fmt.Errorf("%s: clause must have exactly one key, got %d", path, len(clause))
+	}
+
+	for name, body := range clause {
+		parser, ok := dslClauses[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown clause type '%s', expected one of: %s",
+				path, name, strings.Join(dslClauseNames(), ", "))
+		}
+		path = path + "." + name
+		q, err := parser(path, body)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := q.(interface{ Validate() error }); ok {
+			if err = v.Validate(); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		return q, nil
+	}
+	return nil, nil
+}
+
+func dslClauseNames() []string {
+	rv := make([]string, 0, len(dslClauses))
+	for name := range dslClauses {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// decodeDSLClause strictly decodes the body of a clause, so that misspelt
+// options are reported rather than silently ignored
+func decodeDSLClause(path string, raw json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+type dslBool struct {
+	Must      []json.RawMessage `json:"must"`
+	Should    []json.RawMessage `json:"should"`
+	MustNot   []json.RawMessage `json:"must_not"`
+	MinShould int               `json:"min_should"`
+	Boost     *float64          `json:"boost"`
+}
+
+func parseDSLBool(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslBool
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Must) == 0 && len(c.Should) == 0 && len(c.MustNot) == 0 {
+		return nil, fmt.Errorf("%s: at least one must, should or must_not clause is required", path)
+	}
+	if c.MinShould < 0 || c.MinShould > len(c.Should) {
+		return nil, fmt.Errorf("%s: min_should must be between 0 and the number of should clauses (%d), got %d",
+			path, len(c.Should), c.MinShould)
+	}
+
+	q := bluge.NewBooleanQuery()
+	for _, occur := range []struct {
+		name    string
+		clauses []json.RawMessage
+		add     func(m ...bluge.Query) *bluge.BooleanQuery
+	}{
+		{name: "must", clauses: c.Must, add: q.AddMust},
+		{name: "should", clauses: c.Should, add: q.AddShould},
+		{name: "must_not", clauses: c.MustNot, add: q.AddMustNot},
+	} {
+		for i, clause := range occur.clauses {
+			child, err := parseDSLClause(fmt.Sprintf("%s.%s[%d]", path, occur.name, i), clause)
+			if err != nil {
+				return nil, err
+			}
+			occur.add(child)
+		}
+	}
+	if c.MinShould > 0 {
+		q.SetMinShould(c.MinShould)
+	}
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+type dslTerm struct {
+	Field string   `json:"field"`
+	Term  string   `json:"term"`
+	Boost *float64 `json:"boost"`
+}
+
+func parseDSLTerm(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslTerm
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Term == "" {
+		return nil, fmt.Errorf("%s: term is required", path)
+	}
+	q := bluge.NewTermQuery(c.Term)
+	if c.Field != "" {
+		q.SetField(c.Field)
+	}
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+type dslMatch struct {
+	Field     string   `json:"field"`
+	Match     string   `json:"match"`
+	Operator  string   `json:"operator"`
+	Fuzziness int      `json:"fuzziness"`
+	Boost     *float64 `json:"boost"`
+}
+
+func parseDSLMatch(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslMatch
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Match == "" {
+		return nil, fmt.Errorf("%s: match is required", path)
+	}
+	if c.Fuzziness < 0 || c.Fuzziness > maxFuzziness {
+		return nil, fmt.Errorf("%s: fuzziness must be between 0 and %d, got %d", path, maxFuzziness, c.Fuzziness)
+	}
+	var operator bluge.MatchQueryOperator = bluge.MatchQueryOperatorOr
+	switch c.Operator {
+	case "", "or":
+	case "and":
+		operator = bluge.MatchQueryOperatorAnd
+	default:
+		return nil, fmt.Errorf("%s: operator must be 'and' or 'or', got '%s'", path, c.Operator)
+	}
+	build := func(text, language string) *bluge.MatchQuery {
+		q := bluge.NewMatchQuery(text).SetOperator(operator)
+		if c.Field != "" {
+			q.SetField(c.Field)
+		}
+		if fieldAnalyzer := textAnalysis.Analyzer(analyzedField(c.Field), language); fieldAnalyzer != nil {
+			q.SetAnalyzer(fieldAnalyzer)
+		}
+		if c.Fuzziness > 0 {
+			q.SetFuzziness(c.Fuzziness)
+		}
+		return q
+	}
+	q := build(c.Match, "")
+	var alternatives []bluge.Query
+	for _, language := range textAnalysis.Languages(analyzedField(c.Field)) {
+		alternatives = append(alternatives, build(c.Match, language))
+	}
+	if synonymFields[c.Field] && operator == bluge.MatchQueryOperatorAnd {
+		for _, variant := range synonyms.ExpandText(c.Match)[1:] {
+			alternatives = append(alternatives, build(variant, ""))
+		}
+	} else if synonymFields[c.Field] {
+		// any term may match, so only the synonyms themselves are added,
+		// as phrases so the words of a synonym do not match on their own
+		for _, synonym := range synonyms.Find(c.Match) {
+			alternatives = append(alternatives, newDSLPhraseQuery(c.Field, synonym, ""))
+		}
+	}
+	if len(alternatives) == 0 {
+		if c.Boost != nil {
+			q.SetBoost(*c.Boost)
+		}
+		return q, nil
+	}
+	return synonymQuery(q, alternatives, c.Boost), nil
+}
+
+// synonymQuery matches either the query or one of its alternatives with
+// synonyms
+func synonymQuery(q bluge.Query, alternatives []bluge.Query, boost *float64) bluge.Query {
+	rv := bluge.NewBooleanQuery().AddShould(q).AddShould(alternatives...)
+	rv.SetMinShould(1)
+	if boost != nil {
+		rv.SetBoost(*boost)
+	}
+	return rv
+}
+
+type dslPhrase struct {
+	Field  string   `json:"field"`
+	Phrase string   `json:"phrase"`
+	Boost  *float64 `json:"boost"`
+}
+
+func parseDSLPhrase(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslPhrase
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Phrase == "" {
+		return nil, fmt.Errorf("%s: phrase is required", path)
+	}
+	q := newDSLPhraseQuery(c.Field, c.Phrase, "")
+	var alternatives []bluge.Query
+	for _, language := range textAnalysis.Languages(analyzedField(c.Field)) {
+		alternatives = append(alternatives, newDSLPhraseQuery(c.Field, c.Phrase, language))
+	}
+	if synonymFields[c.Field] {
+		for _, variant := range synonyms.ExpandText(c.Phrase)[1:] {
+			alternatives = append(alternatives, newDSLPhraseQuery(c.Field, variant, ""))
+		}
+	}
+	if len(alternatives) == 0 {
+		if c.Boost != nil {
+			q.SetBoost(*c.Boost)
+		}
+		return q, nil
+	}
+	return synonymQuery(q, alternatives, c.Boost), nil
+}
+
+// newDSLPhraseQuery builds the phrase query, analyzed the way the field is
+// analyzed for documents in the language
+func newDSLPhraseQuery(field, phrase, language string) *bluge.MatchPhraseQuery {
+	q := bluge.NewMatchPhraseQuery(phrase)
+	if field != "" {
+		q.SetField(field)
+	}
+	if fieldAnalyzer := textAnalysis.Analyzer(analyzedField(field), language); fieldAnalyzer != nil {
+		q.SetAnalyzer(fieldAnalyzer)
+	}
+	return q
+}
+
+type dslFuzzy struct {
+	Field     string   `json:"field"`
+	Term      string   `json:"term"`
+	Fuzziness *int     `json:"fuzziness"`
+	Prefix    int      `json:"prefix"`
+	Boost     *float64 `json:"boost"`
+}
+
+func parseDSLFuzzy(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslFuzzy
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Term == "" {
+		return nil, fmt.Errorf("%s: term is required", path)
+	}
+	q := bluge.NewFuzzyQuery(c.Term)
+	if c.Fuzziness != nil {
+		if *c.Fuzziness < 0 || *c.Fuzziness > maxFuzziness {
+			return nil, fmt.Errorf("%s: fuzziness must be between 0 and %d, got %d", path, maxFuzziness, *c.Fuzziness)
+		}
+		q.SetFuzziness(*c.Fuzziness)
+	}
+	if c.Prefix < 0 {
+		return nil, fmt.Errorf("%s: prefix must not be negative, got %d", path, c.Prefix)
+	}
+	q.SetPrefix(c.Prefix)
+	if c.Field != "" {
+		q.SetField(c.Field)
+	}
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+type dslNumericRange struct {
+	Field        string   `json:"field"`
+	Min          *float64 `json:"min"`
+	Max          *float64 `json:"max"`
+	InclusiveMin *bool    `json:"inclusive_min"`
+	InclusiveMax *bool    `json:"inclusive_max"`
+	Boost        *float64 `json:"boost"`
+}
+
+func parseDSLNumericRange(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslNumericRange
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Field == "" {
+		return nil, fmt.Errorf("%s: field is required", path)
+	}
+	if c.Min == nil && c.Max == nil {
+		return nil, fmt.Errorf("%s: min or max is required", path)
+	}
+	min, max := bluge.MinNumeric, bluge.MaxNumeric
+	if c.Min != nil {
+		min = *c.Min
+	}
+	if c.Max != nil {
+		max = *c.Max
+	}
+	if min > max {
+		return nil, fmt.Errorf("%s: min %g is greater than max %g", path, min, max)
+	}
+	// inclusive of the min and exclusive of the max, like the query string
+	q := bluge.NewNumericRangeInclusiveQuery(min, max,
+		c.InclusiveMin == nil || *c.InclusiveMin, c.InclusiveMax != nil && *c.InclusiveMax).
+		SetField(c.Field)
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+type dslDateRange struct {
+	Field          string   `json:"field"`
+	Start          string   `json:"start"`
+	End            string   `json:"end"`
+	InclusiveStart *bool    `json:"inclusive_start"`
+	InclusiveEnd   *bool    `json:"inclusive_end"`
+	Boost          *float64 `json:"boost"`
+}
+
+func parseDSLDateRange(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslDateRange
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Field == "" {
+		return nil, fmt.Errorf("%s: field is required", path)
+	}
+	if c.Start == "" && c.End == "" {
+		return nil, fmt.Errorf("%s: start or end is required", path)
+	}
+	start, err := parseDSLDate(c.Start)
+	if err != nil {
+		return nil, fmt.Errorf("%s: error parsing start: %v", path, err)
+	}
+	end, err := parseDSLDate(c.End)
+	if err != nil {
+		return nil, fmt.Errorf("%s: error parsing end: %v", path, err)
+	}
+	if !start.IsZero() && !end.IsZero() && start.After(end) {
+		return nil, fmt.Errorf("%s: start %s is after end %s", path, c.Start, c.End)
+	}
+	q := bluge.NewDateRangeInclusiveQuery(start, end,
+		c.InclusiveStart == nil || *c.InclusiveStart, c.InclusiveEnd != nil && *c.InclusiveEnd).
+		SetField(c.Field)
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+// parseDSLDate parses a date in any of the dslDateFormats, the empty
+// string leaves that end of the range open
+func parseDSLDate(in string) (time.Time, error) {
+	if in == "" {
+		return time.Time{}, nil
+	}
+	for _, format := range dslDateFormats {
+		t, err := time.Parse(format, in)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a date in one of the formats: %s",
+		in, strings.Join(dslDateFormats, ", "))
+}
+
+type dslGeoDistance struct {
+	Field    string   `json:"field"`
+	Lat      *float64 `json:"lat"`
+	Lon      *float64 `json:"lon"`
+	Distance string   `json:"distance"`
+	Boost    *float64 `json:"boost"`
+}
+
+func parseDSLGeoDistance(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslGeoDistance
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Lat == nil || c.Lon == nil {
+		return nil, fmt.Errorf("%s: lat and lon are required", path)
+	}
+	if c.Distance == "" {
+		return nil, fmt.Errorf("%s: distance is required", path)
+	}
+	if _, err := geo.ParseDistance(c.Distance); err != nil {
+		return nil, fmt.Errorf("%s: error parsing distance '%s': %v", path, c.Distance, err)
+	}
+	if c.Field == "" {
+		c.Field = locationField
+	}
+	q := bluge.NewGeoDistanceQuery(*c.Lon, *c.Lat, c.Distance).SetField(c.Field)
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+type dslPrefix struct {
+	Field  string   `json:"field"`
+	Prefix string   `json:"prefix"`
+	Boost  *float64 `json:"boost"`
+}
+
+func parseDSLPrefix(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslPrefix
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Prefix == "" {
+		return nil, fmt.Errorf("%s: prefix is required", path)
+	}
+	q := bluge.NewPrefixQuery(c.Prefix)
+	if c.Field != "" {
+		q.SetField(c.Field)
+	}
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+type dslWildcard struct {
+	Field    string   `json:"field"`
+	Wildcard string   `json:"wildcard"`
+	Boost    *float64 `json:"boost"`
+}
+
+func parseDSLWildcard(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c dslWildcard
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	if c.Wildcard == "" {
+		return nil, fmt.Errorf("%s: wildcard is required", path)
+	}
+	q := bluge.NewWildcardQuery(c.Wildcard)
+	if c.Field != "" {
+		q.SetField(c.Field)
+	}
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
+
+func parseDSLMatchAll(path string, raw json.RawMessage) (bluge.Query, error) {
+	var c struct {
+		Boost *float64 `json:"boost"`
+	}
+	if err := decodeDSLClause(path, raw, &c); err != nil {
+		return nil, err
+	}
+	q := bluge.NewMatchAllQuery()
+	if c.Boost != nil {
+		q.SetBoost(*c.Boost)
+	}
+	return q, nil
+}
@@ -0,0 +1,209 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestParseQueryDSL(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		expect    bluge.Query
+		errPrefix string
+	}{
+		{
+			name:   "term",
+			in:     `{"term": {"field": "type", "term": "beer"}}`,
+			expect: bluge.NewTermQuery("beer").SetField("type"),
+		},
+		{
+			name: "bool",
+			in: `{"bool": {
+				"must": [{"match": {"field": "name", "match": "pale ale", "operator": "and"}}],
+				"should": [{"phrase": {"field": "desc", "phrase": "hop aroma"}}],
+				"must_not": [{"numeric_range": {"field": "abv", "min": 8}}]
+			}}`,
+			expect: bluge.NewBooleanQuery().
+				AddMust(bluge.NewMatchQuery("pale ale").SetField("name").SetOperator(bluge.MatchQueryOperatorAnd).
+					SetAnalyzer(analyzers["en"])).
+				AddShould(bluge.NewMatchPhraseQuery("hop aroma").SetField("desc").SetAnalyzer(analyzers["en"])).
+				AddMustNot(bluge.NewNumericRangeQuery(8, bluge.MaxNumeric).SetField("abv")),
+		},
+		{
+			name: "date range",
+			in:   `{"date_range": {"field": "updated", "start": "2010-07-01", "end": "2010-10-01 00:00:00"}}`,
+			expect: bluge.NewDateRangeQuery(mustTimeParse(time.RFC3339, "2010-07-01T00:00:00Z"),
+				mustTimeParse(time.RFC3339, "2010-10-01T00:00:00Z")).SetField("updated"),
+		},
+		{
+			name:   "geo distance",
+			in:     `{"geo_distance": {"lat": 40.7, "lon": -76.1747, "distance": "25km"}}`,
+			expect: bluge.NewGeoDistanceQuery(-76.1747, 40.7, "25km").SetField(locationField),
+		},
+		{
+			name:   "fuzzy",
+			in:     `{"fuzzy": {"field": "name", "term": "yuengleng", "fuzziness": 2}}`,
+			expect: bluge.NewFuzzyQuery("yuengleng").SetField("name").SetFuzziness(2).SetPrefix(0),
+		},
+		{
+			name:      "not an object",
+			in:        `["term"]`,
+			errPrefix: "query_dsl: clause must be an object",
+		},
+		{
+			name:      "two keys",
+			in:        `{"term": {"term": "beer"}, "prefix": {"prefix": "be"}}`,
+			errPrefix: "query_dsl: clause must have exactly one key",
+		},
+		{
+			name:      "unknown clause",
+			in:        `{"bool": {"must": [{"term": {"term": "beer"}}, {"regexp": {}}]}}`,
+			errPrefix: "query_dsl.bool.must[1]: unknown clause type 'regexp'",
+		},
+		{
+			name:      "unknown option",
+			in:        `{"bool": {"should": [{"wildcard": {"field": "name", "wildcard": "yu*", "fuzziness": 1}}]}}`,
+			errPrefix: "query_dsl.bool.should[0].wildcard: json: unknown field",
+		},
+		{
+			name:      "open numeric range",
+			in:        `{"bool": {"must": [{"match_all": {}}], "must_not": [{"numeric_range": {"field": "abv"}}]}}`,
+			errPrefix: "query_dsl.bool.must_not[0].numeric_range: min or max is required",
+		},
+		{
+			name:      "bad date",
+			in:        `{"date_range": {"field": "updated", "start": "2010-Q3"}}`,
+			errPrefix: "query_dsl.date_range: error parsing start",
+		},
+		{
+			name:      "bad distance",
+			in:        `{"geo_distance": {"lat": 40.7, "lon": -76.1747, "distance": "far"}}`,
+			errPrefix: "query_dsl.geo_distance: error parsing distance",
+		},
+		{
+			name:      "empty bool",
+			in:        `{"bool": {}}`,
+			errPrefix: "query_dsl.bool: at least one must",
+		},
+		{
+			name:      "wrong type",
+			in:        `{"term": {"term": 42}}`,
+			errPrefix: "query_dsl.term: json: cannot unmarshal number",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			q, err := ParseQueryDSL(json.RawMessage(test.in))
+			if test.errPrefix != "" {
+				if err == nil {
+					t.Fatalf("expected error, got query: %#v", q)
+				}
+				if !strings.HasPrefix(err.Error(), test.errPrefix) {
+					t.Errorf("expected error starting with '%s', got '%v'", test.errPrefix, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(q, test.expect) {
+				t.Errorf("expected query: %#v, got: %#v", test.expect, q)
+			}
+		})
+	}
+}
+
+func TestSearchRequestUserQuery(t *testing.T) {
+	dsl := json.RawMessage(`{"term": {"field": "type", "term": "beer"}}`)
+
+	q, err := (&SearchRequest{QueryDSL: dsl}).UserQuery()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := q.(*bluge.TermQuery); !ok {
+		t.Errorf("expected the query DSL alone to be used, got: %#v", q)
+	}
+
+	q, err = (&SearchRequest{Query: "porter", QueryDSL: dsl}).UserQuery()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := q.(*bluge.BooleanQuery); !ok {
+		t.Errorf("expected the query string and DSL to be combined, got: %#v", q)
+	}
+}
+
+func TestParseQueryDSLSynonyms(t *testing.T) {
+	defer func(previous *Synonyms) {
+		synonyms = previous
+	}(synonyms)
+	synonyms = testSynonymSet(t)
+
+	boost := 2.0
+	tests := []struct {
+		name   string
+		in     string
+		expect bluge.Query
+	}{
+		{
+			name: "match any term",
+			in:   `{"match": {"field": "name", "match": "hazy ipa", "boost": 2}}`,
+			expect: synonymQuery(bluge.NewMatchQuery("hazy ipa").SetField("name").SetAnalyzer(analyzers["en"]),
+				[]bluge.Query{bluge.NewMatchPhraseQuery("india pale ale").SetField("name").SetAnalyzer(analyzers["en"])}, &boost),
+		},
+		{
+			name: "match every term",
+			in:   `{"match": {"match": "hazy ipa", "operator": "and"}}`,
+			expect: synonymQuery(bluge.NewMatchQuery("hazy ipa").SetOperator(bluge.MatchQueryOperatorAnd).
+				SetAnalyzer(analyzers["en"]), []bluge.Query{bluge.NewMatchQuery("hazy india pale ale").
+				SetOperator(bluge.MatchQueryOperatorAnd).SetAnalyzer(analyzers["en"])}, nil),
+		},
+		{
+			name: "phrase",
+			in:   `{"phrase": {"field": "style", "phrase": "imperial double ipa"}}`,
+			expect: synonymQuery(bluge.NewMatchPhraseQuery("imperial double ipa").SetField("style").
+				SetAnalyzer(analyzers["en"]), []bluge.Query{bluge.NewMatchPhraseQuery("imperial dipa").
+				SetField("style").SetAnalyzer(analyzers["en"])}, nil),
+		},
+		{
+			name:   "field without synonyms",
+			in:     `{"match": {"field": "brewery_id", "match": "ipa", "boost": 2}}`,
+			expect: bluge.NewMatchQuery("ipa").SetField("brewery_id").SetBoost(boost),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseQueryDSL(json.RawMessage(test.in))
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected query: %#v, got: %#v", test.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/blugelabs/bluge"
+)
+
+// BreweryStats are rolled up from the beers sharing a brewery_id, and
+// stored with the brewery
+type BreweryStats struct {
+	BeerCount  int            `json:"beer_count"`
+	Styles     map[string]int `json:"styles,omitempty"`
+	Categories map[string]int `json:"categories,omitempty"`
+	ABV        *Summary       `json:"abv,omitempty"`
+	IBU        *Summary       `json:"ibu,omitempty"`
+}
+
+// Summary describes the spread of the known values of a beer property
+type Summary struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+// MainStyle is the style most of the beers are brewed in
+func (s *BreweryStats) MainStyle() string {
+	return mostCommon(s.Styles)
+}
+
+// MainCategory is the category most of the beers belong to
+func (s *BreweryStats) MainCategory() string {
+	return mostCommon(s.Categories)
+}
+
+// mostCommon returns the value counted most often, ties go to the value
+// sorting first so the result is stable
+func mostCommon(counts map[string]int) string {
+	var rv string
+	var max int
+	for value, count := range counts {
+		if count > max || (count == max && value < rv) {
+			rv, max = value, count
+		}
+	}
+	return rv
+}
+
+// breweryStatsBuilder accumulates the beers of one brewery
+type breweryStatsBuilder struct {
+	count      int
+	styles     map[string]int
+	categories map[string]int
+	abv        []float64
+	ibu        []float64
+}
+
+func newBreweryStatsBuilder() *breweryStatsBuilder {
+	return &breweryStatsBuilder{
+		styles:     make(map[string]int),
+		categories: make(map[string]int),
+	}
+}
+
+// Add counts the beer, an ABV or IBU of zero is unknown and left out of
+// the summaries
+func (b *breweryStatsBuilder) Add(beer *Beer) {
+	b.count++
+	if beer.Style != "" {
+		b.styles[beer.Style]++
+	}
+	if beer.Category != "" {
+		b.categories[beer.Category]++
+	}
+	if beer.ABV > 0 {
+		b.abv = append(b.abv, beer.ABV)
+	}
+	if beer.IBU > 0 {
+		b.ibu = append(b.ibu, beer.IBU)
+	}
+}
+
+func (b *breweryStatsBuilder) Stats() *BreweryStats {
+	rv := &BreweryStats{
+		BeerCount: b.count,
+		ABV:       summarize(b.abv),
+		IBU:       summarize(b.ibu),
+	}
+	if len(b.styles) > 0 {
+		rv.Styles = b.styles
+	}
+	if len(b.categories) > 0 {
+		rv.Categories = b.categories
+	}
+	return rv
+}
+
+// summarize returns the min, median and max of the values, the median of
+// an even number of values is the mean of the middle two
+func summarize(values []float64) *Summary {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	median := sorted[mid]
+	if len(sorted)%2 == 0 {
+		median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return &Summary{
+		Min:    sorted[0],
+		Median: median,
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// breweryRollups accumulates the beers of every brewery, keyed by brewery ID
+type breweryRollups map[string]*breweryStatsBuilder
+
+func (r breweryRollups) Add(beer *Beer) {
+	builder, ok := r[beer.BreweryID]
+	if !ok {
+		builder = newBreweryStatsBuilder()
+		r[beer.BreweryID] = builder
+	}
+	builder.Add(beer)
+}
+
+// Stats returns the stats of the brewery, a brewery without any beers
+// still has a beer count of zero
+func (r breweryRollups) Stats(breweryID string) *BreweryStats {
+	if builder, ok := r[breweryID]; ok {
+		return builder.Stats()
+	}
+	return newBreweryStatsBuilder().Stats()
+}
+
+// BreweryRollup keeps the stats stored with breweries current as beers
+// are added, changed and removed after the initial indexing
+type BreweryRollup struct {
+	beerIndexWriter    *bluge.Writer
+	breweryIndexWriter *bluge.Writer
+
+	// held from reading the beers until the breweries are written, so that
+	// concurrent refreshes can't overwrite newer stats with older ones
+	m sync.Mutex
+}
+
+func NewBreweryRollup(beerIndexWriter, breweryIndexWriter *bluge.Writer) *BreweryRollup {
+	return &BreweryRollup{
+		beerIndexWriter:    beerIndexWriter,
+		breweryIndexWriter: breweryIndexWriter,
+	}
+}
+
+// Refresh recomputes the stats of the breweries from the beers currently
+// indexed, and reindexes the breweries with them.  Breweries which are not
+// indexed are skipped.
+func (r *BreweryRollup) Refresh(breweryIDs ...string) error {
+	if len(breweryIDs) == 0 {
+		return nil
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	beerReader, breweryReader, err := openReaders(r.beerIndexWriter, r.breweryIndexWriter)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = beerReader.Close()
+		_ = breweryReader.Close()
+	}()
+
+	batch := bluge.NewBatch()
+	seen := make(map[string]struct{}, len(breweryIDs))
+	for _, breweryID := range breweryIDs {
+		if _, ok := seen[breweryID]; ok {
+			continue
+		}
+		seen[breweryID] = struct{}{}
+
+		source, err := loadSource(breweryReader, breweryID)
+		if err != nil {
+			return fmt.Errorf("error loading brewery '%s': %v", breweryID, err)
+		}
+		if source == nil {
+			continue
+		}
+		obj, _, err := unmarshalByType(typeBrewery, breweryID, source)
+		if err != nil {
+			return fmt.Errorf("error parsing brewery '%s': %v", breweryID, err)
+		}
+		brewery := obj.(*Brewery)
+		brewery.Stats, err = beerStats(beerReader, breweryID)
+		if err != nil {
+			return fmt.Errorf("error rolling up beers of '%s': %v", breweryID, err)
+		}
+		doc, err := brewery.Document(source)
+		if err != nil {
+			return fmt.Errorf("error mapping brewery '%s': %v", breweryID, err)
+		}
+		batch.Update(doc.ID(), doc)
+	}
+	return r.breweryIndexWriter.Batch(batch)
+}
+
+// beerStats rolls up the indexed beers of the brewery
+func beerStats(beerReader *bluge.Reader, breweryID string) (*BreweryStats, error) {
+	builder := newBreweryStatsBuilder()
+	count, err := beerReader.Count()
+	if err != nil || count == 0 {
+		return builder.Stats(), err
+	}
+
+	q := bluge.NewTermQuery(breweryID).SetField(breweryAggregation)
+	dmi, err := beerReader.Search(context.Background(), bluge.NewTopNSearch(int(count), q))
+	if err != nil {
+		return nil, err
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		var doc Indexable
+		_, doc, err = matchToIndexable(next)
+		if err != nil {
+			return nil, err
+		}
+		if beer, ok := doc.(*Beer); ok {
+			builder.Add(beer)
+		}
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return builder.Stats(), nil
+}
@@ -0,0 +1,153 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     []float64
+		expect *Summary
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:   "odd",
+			in:     []float64{5.4, 3.4, 4.4},
+			expect: &Summary{Min: 3.4, Median: 4.4, Max: 5.4},
+		},
+		{
+			name:   "even",
+			in:     []float64{8, 2, 4, 6},
+			expect: &Summary{Min: 2, Median: 5, Max: 8},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := summarize(test.in)
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected summary: %#v, got: %#v", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestBreweryStatsMainStyle(t *testing.T) {
+	stats := &BreweryStats{
+		Styles: map[string]int{"Porter": 2, "Bock": 2, "Lager": 1},
+	}
+	if got := stats.MainStyle(); got != "Bock" {
+		t.Errorf("expected ties to go to the first style, got '%s'", got)
+	}
+	if got := (&BreweryStats{}).MainCategory(); got != "" {
+		t.Errorf("expected no main category, got '%s'", got)
+	}
+}
+
+func TestBreweryRollupRefresh(t *testing.T) {
+	// in memory indexes are unable to apply a second batch
+	dir := t.TempDir()
+	beerIndexWriter, err := bluge.OpenWriter(bluge.DefaultConfig(filepath.Join(dir, "beers.bluge")).
+		WithVirtualField(bluge.NewKeywordField("_type", typeBeer).StoreValue()))
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	defer func() {
+		_ = beerIndexWriter.Close()
+	}()
+	breweryIndexWriter, err := bluge.OpenWriter(bluge.DefaultConfig(filepath.Join(dir, "breweries.bluge")).
+		WithVirtualField(bluge.NewKeywordField("_type", typeBrewery).StoreValue()))
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	defer func() {
+		_ = breweryIndexWriter.Close()
+	}()
+	files, err := ioutil.ReadDir("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	beers := bluge.NewBatch()
+	breweries := bluge.NewBatch()
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "yuengling_son_brewing") {
+			continue
+		}
+		obj, doc, err := parseAndBuildDoc("data", file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := obj.(*Beer); ok {
+			beers.Update(doc.ID(), doc)
+		} else {
+			breweries.Update(doc.ID(), doc)
+		}
+	}
+	err = beerIndexWriter.Batch(beers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = breweryIndexWriter.Batch(breweries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewBreweryRollup(beerIndexWriter, breweryIndexWriter).Refresh("yuengling_son_brewing", "no_such_brewery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breweryReader, err := breweryIndexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = breweryReader.Close()
+	}()
+	source, err := loadSource(breweryReader, "yuengling_son_brewing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, _, err := unmarshalByType(typeBrewery, "yuengling_son_brewing", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := obj.(*Brewery).Stats
+	if stats == nil {
+		t.Fatalf("expected brewery stats, got none")
+	}
+	if stats.BeerCount != 8 {
+		t.Errorf("expected 8 beers, got %d", stats.BeerCount)
+	}
+	expectABV := &Summary{Min: 3.4, Median: 4.4, Max: 5.4}
+	if !reflect.DeepEqual(stats.ABV, expectABV) {
+		t.Errorf("expected abv summary: %#v, got: %#v", expectABV, stats.ABV)
+	}
+	if stats.MainCategory() != "North American Lager" {
+		t.Errorf("expected main category North American Lager, got '%s'", stats.MainCategory())
+	}
+}
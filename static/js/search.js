@@ -46,6 +46,10 @@ $(document).ready(function () {
         return roundScore(number);
     });
 
+    Handlebars.registerHelper('encodeURI', function(value) {
+        return encodeURIComponent(value);
+    });
+
     $("#searchForm").submit(function() {
         newq = $("#query").val();
         if (newq !== userQuery) {
@@ -75,6 +79,10 @@ $(document).ready(function () {
             "query": userQuery,
             "filters": filters,
             "page": parseInt(page),
+            "highlight": true,
+            "aggregations": facets.map(function(name) {
+                return {"name": name};
+            }),
         }
         $.ajax({
             type: "POST",
@@ -102,7 +110,7 @@ function resubmit() {
     $("#searchForm").submit();
 }
 
-var facets = ["type", "style-facet", "updated", "abv"];
+var facets = ["type", "style-facet", "category-facet", "brewery_id", "country-facet", "state-facet", "city-facet", "updated", "abv"];
 
 function parseFilters() {
     for (var fnamei in facets) {
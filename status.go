@@ -0,0 +1,150 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxStatusErrors is the number of the most recent indexing errors kept
+const maxStatusErrors = 10
+
+// indexStatus tracks the indexing of the server's indexes
+var indexStatus = &IndexStatus{}
+
+// IndexStatus tracks the progress of indexing the JSON directory, and when
+// the indexes last changed.  The indexes are ready to search once the
+// initial indexing has finished.
+type IndexStatus struct {
+	m              sync.RWMutex
+	ready          bool
+	indexing       bool
+	failed         bool
+	filesTotal     int
+	filesProcessed int
+	errorCount     int
+	errors         []string
+	started        time.Time
+	finished       time.Time
+	lastIndexed    time.Time
+}
+
+// IndexingProgress is a snapshot of the indexing status
+type IndexingProgress struct {
+	Ready          bool       `json:"ready"`
+	Indexing       bool       `json:"indexing"`
+	Failed         bool       `json:"failed"`
+	FilesProcessed int        `json:"files_processed"`
+	FilesTotal     int        `json:"files_total"`
+	ErrorCount     int        `json:"error_count"`
+	Errors         []string   `json:"errors,omitempty"`
+	Started        *time.Time `json:"started,omitempty"`
+	Finished       *time.Time `json:"finished,omitempty"`
+	LastIndexed    *time.Time `json:"last_indexed,omitempty"`
+}
+
+// Start records that indexing the files has started
+func (s *IndexStatus) Start(filesTotal int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.indexing = true
+	s.failed = false
+	s.filesTotal = filesTotal
+	s.filesProcessed = 0
+	s.started = time.Now()
+	s.finished = time.Time{}
+}
+
+// FileProcessed records that another file has been read
+func (s *IndexStatus) FileProcessed() {
+	s.m.Lock()
+	s.filesProcessed++
+	s.m.Unlock()
+}
+
+// Indexed records that the indexes have changed
+func (s *IndexStatus) Indexed() {
+	s.m.Lock()
+	s.lastIndexed = time.Now()
+	s.m.Unlock()
+}
+
+// Error records an error indexing
+func (s *IndexStatus) Error(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.errorCount++
+	s.errors = append(s.errors, err.Error())
+	if len(s.errors) > maxStatusErrors {
+		s.errors = s.errors[len(s.errors)-maxStatusErrors:]
+	}
+}
+
+// Finish records that indexing the files has finished, the indexes are
+// ready unless it failed
+func (s *IndexStatus) Finish(err error) {
+	if err != nil {
+		s.Error(err)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.indexing = false
+	s.failed = err != nil
+	s.finished = time.Now()
+	if err == nil {
+		s.ready = true
+	}
+}
+
+// SetReady marks the indexes ready without indexing, when serving existing
+// indexes
+func (s *IndexStatus) SetReady() {
+	s.m.Lock()
+	s.ready = true
+	s.m.Unlock()
+}
+
+// Ready returns whether the indexes are ready to search
+func (s *IndexStatus) Ready() bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.ready
+}
+
+// Progress returns a snapshot of the indexing status
+func (s *IndexStatus) Progress() *IndexingProgress {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return &IndexingProgress{
+		Ready:          s.ready,
+		Indexing:       s.indexing,
+		Failed:         s.failed,
+		FilesProcessed: s.filesProcessed,
+		FilesTotal:     s.filesTotal,
+		ErrorCount:     s.errorCount,
+		Errors:         append([]string(nil), s.errors...),
+		Started:        optionalTime(s.started),
+		Finished:       optionalTime(s.finished),
+		LastIndexed:    optionalTime(s.lastIndexed),
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,431 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// synonymFields are the fields synonyms apply to, no field is the default
+// search field _all
+var synonymFields = map[string]bool{
+	"":         true,
+	"_all":     true,
+	"name":     true,
+	"desc":     true,
+	"style":    true,
+	"category": true,
+}
+
+// maxSynonymVariants limits the alternative queries tried when several
+// required terms have synonyms
+const maxSynonymVariants = 16
+
+var synonymEntryRegexp = regexp.MustCompile(`^[\p{L}\p{N}' -]+$`)
+var synonymWordRegexp = regexp.MustCompile(`^[\p{L}\p{N}'-]+$`)
+var synonymFieldRegexp = regexp.MustCompile(`^[\w.-]+$`)
+
+// synonyms are applied to every query, they are empty until loaded
+var synonyms = &Synonyms{}
+
+// Synonyms expands the terms and phrases of queries with their equivalents,
+// read from a file with one group of equivalent terms or phrases per line,
+// separated by commas.  Lines starting with # are comments.
+type Synonyms struct {
+	path string
+
+	m        sync.RWMutex
+	modTime  time.Time
+	groups   map[string][]string
+	maxWords int
+}
+
+// ParseSynonyms reads groups of synonyms, returning the equivalents of
+// every term or phrase, in lower case, and the most words in a phrase
+func ParseSynonyms(r io.Reader) (groups map[string][]string, maxWords int, err error) {
+	groups = make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var group []string
+		for _, entry := range strings.Split(text, ",") {
+			entry = strings.Join(strings.Fields(strings.ToLower(entry)), " ")
+			if entry == "" {
+				continue
+			}
+			if !synonymEntryRegexp.MatchString(entry) {
+				return nil, 0, fmt.Errorf("line %d: synonym '%s' may only contain letters, digits, spaces, ' and -", line, entry)
+			}
+			group = append(group, entry)
+		}
+		if len(group) < 2 {
+			return nil, 0, fmt.Errorf("line %d: a synonym group needs at least two entries", line)
+		}
+		for _, entry := range group {
+			for _, other := range group {
+				if other != entry && !containsString(groups[entry], other) {
+					groups[entry] = append(groups[entry], other)
+				}
+			}
+			if words := len(strings.Fields(entry)); words > maxWords {
+				maxWords = words
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return groups, maxWords, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads the synonyms file at the path, a missing file has no
+// synonyms until it is created
+func (s *Synonyms) Load(path string) error {
+	s.path = path
+	_, err := s.Reload()
+	return err
+}
+
+// Reload reads the synonyms file again if it was modified since it was
+// last read, reporting whether it was
+func (s *Synonyms) Reload() (bool, error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		info, err = nil, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var modTime time.Time
+	groups := make(map[string][]string)
+	var maxWords int
+	if info != nil {
+		modTime = info.ModTime()
+		s.m.RLock()
+		unchanged := modTime.Equal(s.modTime)
+		s.m.RUnlock()
+		if unchanged {
+			return false, nil
+		}
+		var f *os.File
+		f, err = os.Open(s.path)
+		if err != nil {
+			return false, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		groups, maxWords, err = ParseSynonyms(f)
+		if err != nil {
+			return false, fmt.Errorf("error parsing synonyms '%s': %v", s.path, err)
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if modTime.Equal(s.modTime) && len(s.groups) == len(groups) {
+		return false, nil
+	}
+	s.modTime, s.groups, s.maxWords = modTime, groups, maxWords
+	return true, nil
+}
+
+// Watch reloads the synonyms file whenever it changes, checking every
+// interval until stop is closed
+func (s *Synonyms) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := s.Reload()
+			if err != nil {
+				log.Printf("error reloading synonyms, keeping the previous synonyms: %v", err)
+			} else if reloaded {
+				log.Printf("Reloaded %d synonyms from '%s'", s.Len(), s.path)
+			}
+		}
+	}
+}
+
+// Len returns the number of terms and phrases with synonyms
+func (s *Synonyms) Len() int {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return len(s.groups)
+}
+
+func (s *Synonyms) snapshot() (groups map[string][]string, maxWords int) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.groups, s.maxWords
+}
+
+// synonymMatch is a run of words with synonyms
+type synonymMatch struct {
+	start, end int
+	synonyms   []string
+}
+
+// findSynonyms finds the longest runs of words with synonyms, from left to
+// right, leaving out words which may not be expanded in a run from start
+func findSynonyms(groups map[string][]string, maxWords int, words []string, expandable func(start, i int) bool) []*synonymMatch {
+	var rv []*synonymMatch
+	for i := 0; i < len(words); {
+		matched := false
+		for n := maxWords; n > 0 && !matched; n-- {
+			if i+n > len(words) {
+				continue
+			}
+			ok := true
+			for j := i; j < i+n && ok; j++ {
+				ok = expandable(i, j)
+			}
+			if !ok {
+				continue
+			}
+			if synonyms, found := groups[strings.ToLower(strings.Join(words[i:i+n], " "))]; found {
+				rv = append(rv, &synonymMatch{start: i, end: i + n, synonyms: synonyms})
+				i += n
+				matched = true
+			}
+		}
+		if !matched {
+			i++
+		}
+	}
+	return rv
+}
+
+// ExpandText returns the text followed by the variants of it with the
+// terms and phrases having synonyms replaced by them
+func (s *Synonyms) ExpandText(text string) []string {
+	groups, maxWords := s.snapshot()
+	words := strings.Fields(text)
+	matches := findSynonyms(groups, maxWords, words, func(_, i int) bool {
+		return synonymWordRegexp.MatchString(words[i])
+	})
+	rv := synonymVariants(words, matches)
+	rv[0] = text
+	return rv
+}
+
+// Find returns the synonyms of the terms and phrases of the text
+func (s *Synonyms) Find(text string) []string {
+	groups, maxWords := s.snapshot()
+	words := strings.Fields(text)
+	var rv []string
+	for _, match := range findSynonyms(groups, maxWords, words, func(_, i int) bool {
+		return synonymWordRegexp.MatchString(words[i])
+	}) {
+		rv = append(rv, match.synonyms...)
+	}
+	return rv
+}
+
+// synonymVariants builds every combination of the original words and the
+// synonyms of the matches, the original words coming first
+func synonymVariants(words []string, matches []*synonymMatch) []string {
+	variants := [][]string{words}
+	for i := len(matches) - 1; i >= 0; i-- {
+		match := matches[i]
+		for _, variant := range variants {
+			for _, synonym := range match.synonyms {
+				if len(variants) >= maxSynonymVariants {
+					break
+				}
+				replaced := append(append(append([]string(nil), variant[:match.start]...), synonym),
+					variant[match.end:]...)
+				variants = append(variants, replaced)
+			}
+		}
+	}
+	rv := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		rv = append(rv, strings.Join(variant, " "))
+	}
+	return rv
+}
+
+// queryClause is a clause of a query string, like +name:"pale ale"
+type queryClause struct {
+	raw    string
+	prefix string
+	field  string
+	word   string
+	phrase string
+}
+
+// parseQueryClause parses the clause, only taking the word or phrase of
+// clauses on fields which may be expanded
+func parseQueryClause(raw string, expandable func(field string) bool) *queryClause {
+	rv := &queryClause{raw: raw}
+	value := raw
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		rv.prefix, value = value[:1], value[1:]
+	}
+	if colon := strings.Index(value, ":"); colon > 0 && synonymFieldRegexp.MatchString(value[:colon]) {
+		rv.field, value = value[:colon], value[colon+1:]
+	}
+	if !expandable(rv.field) {
+		return rv
+	}
+	switch {
+	case synonymWordRegexp.MatchString(value):
+		rv.word = value
+	case len(value) > 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) &&
+		!strings.ContainsAny(value[1:len(value)-1], `"\`):
+		rv.phrase = value[1 : len(value)-1]
+	}
+	return rv
+}
+
+// format writes the term or phrase as a clause with the same prefix and
+// field
+func (c *queryClause) format(prefix, value string) string {
+	if strings.Contains(value, " ") {
+		value = `"` + value + `"`
+	}
+	if c.field != "" {
+		value = c.field + ":" + value
+	}
+	return prefix + value
+}
+
+// splitQueryString splits a query string into its clauses, on whitespace
+// outside of quotes
+func splitQueryString(q string) []string {
+	var rv []string
+	var clause strings.Builder
+	var quoted, escaped bool
+	for _, r := range q {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n' || r == '\r'):
+			if clause.Len() > 0 {
+				rv = append(rv, clause.String())
+				clause.Reset()
+			}
+			continue
+		}
+		clause.WriteRune(r)
+	}
+	if clause.Len() > 0 {
+		rv = append(rv, clause.String())
+	}
+	return rv
+}
+
+// ExpandQueryString returns the query strings to search for, the original
+// query first.  Optional and excluded terms and phrases with synonyms have
+// their synonyms added as more optional or excluded clauses.  Required
+// ones are replaced by their synonyms in alternative query strings.
+func (s *Synonyms) ExpandQueryString(q string) []string {
+	groups, maxWords := s.snapshot()
+	if len(groups) == 0 {
+		return []string{q}
+	}
+
+	raws := splitQueryString(q)
+	clauses := make([]*queryClause, len(raws))
+	words := make([]string, len(raws))
+	for i, raw := range raws {
+		clauses[i] = parseQueryClause(raw, func(field string) bool {
+			return synonymFields[field]
+		})
+		words[i] = clauses[i].word
+	}
+	// runs of words must share the prefix and field to be a phrase
+	matches := findSynonyms(groups, maxWords, words, func(start, i int) bool {
+		return clauses[i].word != "" && clauses[i].prefix == clauses[start].prefix &&
+			clauses[i].field == clauses[start].field
+	})
+	for i, clause := range clauses {
+		if clause.phrase == "" {
+			continue
+		}
+		if phraseSynonyms := s.ExpandText(clause.phrase)[1:]; len(phraseSynonyms) > 0 {
+			matches = append(matches, &synonymMatch{start: i, end: i + 1, synonyms: phraseSynonyms})
+		}
+	}
+
+	if len(matches) == 0 {
+		return []string{q}
+	}
+	return expandClauses(raws, clauses, matches)
+}
+
+// expandClauses adds the alternatives of optional and excluded clauses as
+// more optional or excluded clauses, and replaces required clauses by their
+// alternatives in variants of the clauses, the clauses coming first
+func expandClauses(raws []string, clauses []*queryClause, matches []*synonymMatch) []string {
+	base := append([]string(nil), raws...)
+	var required []*synonymMatch
+	for _, match := range matches {
+		clause := clauses[match.start]
+		if clause.prefix == "+" {
+			required = append(required, match)
+			continue
+		}
+		for _, synonym := range match.synonyms {
+			base = append(base, clause.format(clause.prefix, synonym))
+		}
+	}
+	// required matches are replaced, they must be ordered by position
+	sort.Slice(required, func(i, j int) bool {
+		return required[i].start < required[j].start
+	})
+	var formatted []*synonymMatch
+	for _, match := range required {
+		clause := clauses[match.start]
+		formattedMatch := &synonymMatch{start: match.start, end: match.end}
+		for _, synonym := range match.synonyms {
+			formattedMatch.synonyms = append(formattedMatch.synonyms, clause.format("+", synonym))
+		}
+		formatted = append(formatted, formattedMatch)
+	}
+	return synonymVariants(base, formatted)
+}
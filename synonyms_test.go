@@ -0,0 +1,242 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSynonyms = `# beer synonyms
+ipa, India Pale Ale
+dipa, double ipa
+kolsch, kölsch
+`
+
+func testSynonymSet(t *testing.T) *Synonyms {
+	groups, maxWords, err := ParseSynonyms(strings.NewReader(testSynonyms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Synonyms{groups: groups, maxWords: maxWords}
+}
+
+func TestParseSynonyms(t *testing.T) {
+	tests := []struct {
+		name         string
+		in           string
+		expect       map[string][]string
+		expectWords  int
+		expectErrStr string
+	}{
+		{
+			name: "groups",
+			in:   testSynonyms,
+			expect: map[string][]string{
+				"ipa":            {"india pale ale"},
+				"india pale ale": {"ipa"},
+				"dipa":           {"double ipa"},
+				"double ipa":     {"dipa"},
+				"kolsch":         {"kölsch"},
+				"kölsch":         {"kolsch"},
+			},
+			expectWords: 3,
+		},
+		{
+			name:         "single entry",
+			in:           "ipa\n",
+			expectErrStr: "line 1: a synonym group needs at least two entries",
+		},
+		{
+			name:         "query syntax",
+			in:           "# comment\nipa, \"india pale ale\"\n",
+			expectErrStr: "line 2: synonym '\"india pale ale\"' may only contain letters, digits, spaces, ' and -",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, words, err := ParseSynonyms(strings.NewReader(test.in))
+			if test.expectErrStr != "" {
+				if err == nil || err.Error() != test.expectErrStr {
+					t.Errorf("expected error '%s', got: %v", test.expectErrStr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected synonyms: %v, got: %v", test.expect, got)
+			}
+			if words != test.expectWords {
+				t.Errorf("expected at most %d words, got %d", test.expectWords, words)
+			}
+		})
+	}
+}
+
+func TestExpandQueryString(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		expect []string
+	}{
+		{
+			name:   "no synonyms",
+			in:     "stout  abv:>5",
+			expect: []string{"stout  abv:>5"},
+		},
+		{
+			name:   "optional term",
+			in:     "IPA hoppy",
+			expect: []string{`IPA hoppy "india pale ale"`},
+		},
+		{
+			name:   "optional phrase of terms",
+			in:     "hoppy india pale ale",
+			expect: []string{"hoppy india pale ale ipa"},
+		},
+		{
+			name:   "quoted phrase",
+			in:     `name:"double ipa"`,
+			expect: []string{`name:"double ipa" name:dipa`},
+		},
+		{
+			name:   "excluded term",
+			in:     "-ipa",
+			expect: []string{`-ipa -"india pale ale"`},
+		},
+		{
+			name: "required terms",
+			in:   "+style:ipa +kolsch",
+			expect: []string{
+				"+style:ipa +kolsch",
+				"+style:ipa +kölsch",
+				`+style:"india pale ale" +kolsch`,
+				`+style:"india pale ale" +kölsch`,
+			},
+		},
+		{
+			name:   "terms of different fields",
+			in:     "india name:pale ale",
+			expect: []string{"india name:pale ale"},
+		},
+		{
+			name:   "field without synonyms",
+			in:     "brewery_id:ipa",
+			expect: []string{"brewery_id:ipa"},
+		},
+		{
+			name:   "fuzzy term",
+			in:     "ipa~1",
+			expect: []string{"ipa~1"},
+		},
+	}
+
+	s := testSynonymSet(t)
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := s.ExpandQueryString(test.in)
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected query strings: %q, got: %q", test.expect, got)
+			}
+			for _, q := range got {
+				if _, err := parseQueryString(q); err != nil {
+					t.Errorf("error parsing '%s': %v", q, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandText(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		expect []string
+	}{
+		{
+			name:   "no synonyms",
+			in:     "dark  lager",
+			expect: []string{"dark  lager"},
+		},
+		{
+			name:   "phrase",
+			in:     "Hazy India Pale Ale",
+			expect: []string{"Hazy India Pale Ale", "Hazy ipa"},
+		},
+		{
+			name:   "longest phrase",
+			in:     "double ipa",
+			expect: []string{"double ipa", "dipa"},
+		},
+	}
+
+	s := testSynonymSet(t)
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := s.ExpandText(test.in)
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected texts: %q, got: %q", test.expect, got)
+			}
+		})
+	}
+}
+
+func TestSynonymsReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "synonyms.txt")
+	s := &Synonyms{}
+	err := s.Load(path)
+	if err != nil {
+		t.Fatalf("expected a missing file to have no synonyms, got: %v", err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(testSynonyms), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := s.Reload()
+	if err != nil || !reloaded || s.Len() != 6 {
+		t.Fatalf("expected 6 synonyms reloaded, got %d: %v", s.Len(), err)
+	}
+	reloaded, err = s.Reload()
+	if err != nil || reloaded {
+		t.Errorf("expected an unchanged file not to be reloaded: %v", err)
+	}
+
+	// a bad file keeps the synonyms
+	err = ioutil.WriteFile(path, []byte("ipa\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Reload()
+	if err == nil || s.Len() != 6 {
+		t.Errorf("expected an error keeping 6 synonyms, got %d: %v", s.Len(), err)
+	}
+}
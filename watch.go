@@ -0,0 +1,258 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// DirWatcher polls a directory of JSON files and applies added, modified
+// and deleted files to the beer and brewery indexes
+type DirWatcher struct {
+	dir                string
+	beerIndexWriter    *bluge.Writer
+	breweryIndexWriter *bluge.Writer
+	rollup             *BreweryRollup
+	files              map[string]fileState
+}
+
+func NewDirWatcher(dir string, beerIndexWriter, breweryIndexWriter *bluge.Writer, rollup *BreweryRollup) *DirWatcher {
+	return &DirWatcher{
+		dir:                dir,
+		beerIndexWriter:    beerIndexWriter,
+		breweryIndexWriter: breweryIndexWriter,
+		rollup:             rollup,
+	}
+}
+
+func (w *DirWatcher) scan() (map[string]fileState, error) {
+	dirEntries, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	rv := make(map[string]fileState, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		rv[dirEntry.Name()] = fileState{
+			modTime: dirEntry.ModTime(),
+			size:    dirEntry.Size(),
+		}
+	}
+	return rv, nil
+}
+
+// Snapshot records the current contents of the directory as indexed.  It
+// is taken before the initial indexing, so that files changing while the
+// directory is indexed are applied by the first poll.
+func (w *DirWatcher) Snapshot() error {
+	files, err := w.scan()
+	if err != nil {
+		return err
+	}
+	w.files = files
+	return nil
+}
+
+// Watch records the current contents of the directory, unless a snapshot
+// was taken already, then checks for changes every interval until stop is
+// closed
+func (w *DirWatcher) Watch(interval time.Duration, stop <-chan struct{}) error {
+	if w.files == nil {
+		err := w.Snapshot()
+		if err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			err := w.Poll()
+			if err != nil {
+				log.Printf("error applying changes from '%s': %v", w.dir, err)
+				indexStatus.Error(err)
+			}
+		}
+	}
+}
+
+// Poll applies any changes to the directory since the last poll
+func (w *DirWatcher) Poll() error {
+	files, err := w.scan()
+	if err != nil {
+		return err
+	}
+
+	beers := newChangeBatch(w.beerIndexWriter)
+	breweries := newChangeBatch(w.breweryIndexWriter)
+	batchFor := func(docType string) *changeBatch {
+		if docType == typeBeer {
+			return beers
+		}
+		return breweries
+	}
+	// the stats of these breweries are refreshed once the changes are applied
+	var breweryIDs []string
+	// beers are analyzed in the language of their brewery as last indexed
+	breweryReader, err := w.breweryIndexWriter.Reader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = breweryReader.Close()
+	}()
+
+	for filename, state := range files {
+		if prev, ok := w.files[filename]; ok && prev == state {
+			continue
+		}
+		obj, doc, err := w.buildDoc(filename, breweryReader)
+		if err != nil {
+			// the file is recorded as it is, to be retried once it changes
+			log.Printf("skipping changed file: %v", err)
+			indexStatus.Error(err)
+			continue
+		}
+		docID, docType := docIDFromFilename(filename)
+		breweryIDs = append(breweryIDs, breweryIDFromDocID(docID))
+		if beer, ok := obj.(*Beer); ok {
+			breweryIDs = append(breweryIDs, beer.BreweryID)
+		}
+		err = batchFor(docType).Update(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	for filename := range w.files {
+		if _, ok := files[filename]; ok {
+			continue
+		}
+		docID, docType := docIDFromFilename(filename)
+		breweryIDs = append(breweryIDs, breweryIDFromDocID(docID))
+		err = batchFor(docType).Delete(docID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = beers.Flush()
+	if err != nil {
+		return fmt.Errorf("error executing beer batch: %w", err)
+	}
+	err = breweries.Flush()
+	if err != nil {
+		return fmt.Errorf("error executing brewery batch: %w", err)
+	}
+	if w.rollup != nil && beers.applied+breweries.applied > 0 {
+		err = w.rollup.Refresh(breweryIDs...)
+		if err != nil {
+			return fmt.Errorf("error refreshing brewery stats: %w", err)
+		}
+	}
+
+	if beers.applied+breweries.applied > 0 {
+		log.Printf("Applied %d beer and %d brewery changes from '%s'", beers.applied, breweries.applied, w.dir)
+	}
+
+	w.files = files
+	return nil
+}
+
+// buildDoc parses the file and builds its document, analyzing beers in the
+// language of their brewery
+func (w *DirWatcher) buildDoc(filename string, breweryReader *bluge.Reader) (Indexable, *bluge.Document, error) {
+	obj, jsonBytes, err := parseJSONPath(w.dir, filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing JSON '%s': %w", filename, err)
+	}
+	if beer, ok := obj.(*Beer); ok {
+		beer.Language, err = breweryLanguage(breweryReader, beer.BreweryID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading brewery of '%s': %w", filename, err)
+		}
+	}
+	doc, err := obj.Document(jsonBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error mapping object: %w", err)
+	}
+	return obj, doc, nil
+}
+
+// changeBatch accumulates updates and deletes for a single index, executing
+// them whenever the batch grows beyond batchSize
+type changeBatch struct {
+	indexWriter *bluge.Writer
+	batch       *index.Batch
+	size        int
+	applied     int
+}
+
+func newChangeBatch(indexWriter *bluge.Writer) *changeBatch {
+	return &changeBatch{
+		indexWriter: indexWriter,
+		batch:       bluge.NewBatch(),
+	}
+}
+
+func (c *changeBatch) Update(doc *bluge.Document) error {
+	c.batch.Update(doc.ID(), doc)
+	return c.added()
+}
+
+func (c *changeBatch) Delete(docID string) error {
+	c.batch.Delete(bluge.Identifier(docID))
+	return c.added()
+}
+
+func (c *changeBatch) added() error {
+	c.size++
+	if c.size > *batchSize {
+		return c.Flush()
+	}
+	return nil
+}
+
+func (c *changeBatch) Flush() error {
+	if c.size == 0 {
+		return nil
+	}
+	err := c.indexWriter.Batch(c.batch)
+	if err != nil {
+		return err
+	}
+	c.applied += c.size
+	indexStatus.Indexed()
+	c.batch.Reset()
+	c.size = 0
+	return nil
+}
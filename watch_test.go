@@ -0,0 +1,287 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestDirWatcherPoll(t *testing.T) {
+	dir := t.TempDir()
+	copyFile := func(filename string) {
+		data, err := ioutil.ReadFile(filepath.Join("data", filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, dir, filename, string(data))
+	}
+	copyFile("yuengling_son_brewing.json")
+	copyFile("yuengling_son_brewing-yuengling_lager.json")
+	copyFile("yuengling_son_brewing-yuengling_porter.json")
+
+	beerIndexWriter, breweryIndexWriter := openTestWriters(t)
+	watcher := NewDirWatcher(dir, beerIndexWriter, breweryIndexWriter,
+		NewBreweryRollup(beerIndexWriter, breweryIndexWriter))
+
+	tests := []struct {
+		name      string
+		change    func()
+		expectIDs []string
+		// parts of the source of the documents
+		expectSources map[string]string
+		// the abv range rolled up into the brewery stats
+		expectMin float64
+		expectMax float64
+		// a file recorded although it failed to parse, so that it is only
+		// retried once it changes
+		expectRecorded string
+	}{
+		{
+			name:      "initial",
+			change:    func() {},
+			expectIDs: []string{"yuengling_son_brewing-yuengling_lager", "yuengling_son_brewing-yuengling_porter"},
+			expectMin: 4.4,
+			expectMax: 4.7,
+		},
+		{
+			name: "new",
+			change: func() {
+				copyFile("yuengling_son_brewing-yuengling_lager_light.json")
+			},
+			expectIDs: []string{"yuengling_son_brewing-yuengling_lager", "yuengling_son_brewing-yuengling_lager_light",
+				"yuengling_son_brewing-yuengling_porter"},
+			expectMin: 3.4,
+			expectMax: 4.7,
+		},
+		{
+			name: "changed",
+			change: func() {
+				writeFile(t, dir, "yuengling_son_brewing-yuengling_porter.json",
+					`{"name":"Yuengling Porter","abv":9.5,"brewery_id":"yuengling_son_brewing"}`)
+			},
+			expectIDs: []string{"yuengling_son_brewing-yuengling_lager", "yuengling_son_brewing-yuengling_lager_light",
+				"yuengling_son_brewing-yuengling_porter"},
+			expectSources: map[string]string{"yuengling_son_brewing-yuengling_porter": `"abv":9.5`},
+			expectMin:     3.4,
+			expectMax:     9.5,
+		},
+		{
+			name: "removed",
+			change: func() {
+				err := os.Remove(filepath.Join(dir, "yuengling_son_brewing-yuengling_lager_light.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectIDs: []string{"yuengling_son_brewing-yuengling_lager", "yuengling_son_brewing-yuengling_porter"},
+			expectMin: 4.4,
+			expectMax: 9.5,
+		},
+		{
+			name: "unparsable",
+			change: func() {
+				writeFile(t, dir, "yuengling_son_brewing-yuengling_test.json", `{"name":"Yuengling Test",`)
+			},
+			expectIDs:      []string{"yuengling_son_brewing-yuengling_lager", "yuengling_son_brewing-yuengling_porter"},
+			expectRecorded: "yuengling_son_brewing-yuengling_test.json",
+			expectMin:      4.4,
+			expectMax:      9.5,
+		},
+		{
+			name: "fixed",
+			change: func() {
+				writeFile(t, dir, "yuengling_son_brewing-yuengling_test.json",
+					`{"name":"Yuengling Test","abv":6.0,"brewery_id":"yuengling_son_brewing"}`)
+			},
+			expectIDs: []string{"yuengling_son_brewing-yuengling_lager", "yuengling_son_brewing-yuengling_porter",
+				"yuengling_son_brewing-yuengling_test"},
+			expectSources: map[string]string{"yuengling_son_brewing-yuengling_test": `"abv":6.0`},
+			expectMin:     4.4,
+			expectMax:     9.5,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.change()
+			err := watcher.Poll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources := indexedSources(t, beerIndexWriter)
+			ids := make([]string, 0, len(sources))
+			for id := range sources {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			if !reflect.DeepEqual(ids, test.expectIDs) {
+				t.Errorf("expected beers: %v, got: %v", test.expectIDs, ids)
+			}
+			for id, part := range test.expectSources {
+				if !strings.Contains(sources[id], part) {
+					t.Errorf("expected the source of '%s' to contain %s, got: %s", id, part, sources[id])
+				}
+			}
+			stats := loadBreweryStats(t, breweryIndexWriter, "yuengling_son_brewing")
+			if stats.BeerCount != len(test.expectIDs) {
+				t.Errorf("expected %d beers in the brewery stats, got %d", len(test.expectIDs), stats.BeerCount)
+			}
+			if stats.ABV.Min != test.expectMin || stats.ABV.Max != test.expectMax {
+				t.Errorf("expected abv from %.1f to %.1f, got: %#v", test.expectMin, test.expectMax, stats.ABV)
+			}
+			if test.expectRecorded != "" {
+				if _, ok := watcher.files[test.expectRecorded]; !ok {
+					t.Errorf("expected '%s' to be recorded", test.expectRecorded)
+				}
+			}
+		})
+	}
+}
+
+func TestDirWatcherSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "yuengling_son_brewing-yuengling_test.json",
+		`{"name":"Yuengling Test","abv":5.0,"brewery_id":"yuengling_son_brewing"}`)
+	writeFile(t, dir, "yuengling_son_brewing-yuengling_other.json",
+		`{"name":"Yuengling Other","abv":4.0,"brewery_id":"yuengling_son_brewing"}`)
+
+	beerIndexWriter, breweryIndexWriter := openTestWriters(t)
+	watcher := NewDirWatcher(dir, beerIndexWriter, breweryIndexWriter, nil)
+	err := watcher.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changed while the snapshot is indexed, the other file is taken to be
+	// indexed already
+	writeFile(t, dir, "yuengling_son_brewing-yuengling_test.json",
+		`{"name":"Yuengling Test","abv":6.0,"brewery_id":"yuengling_son_brewing"}`)
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(filepath.Join(dir, "yuengling_son_brewing-yuengling_test.json"), later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = watcher.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := indexedSources(t, beerIndexWriter)
+	if len(sources) != 1 || !strings.Contains(sources["yuengling_son_brewing-yuengling_test"], `"abv":6.0`) {
+		t.Errorf("expected only the changed beer to be applied, got: %v", sources)
+	}
+}
+
+// openTestWriters opens beer and brewery indexes in a temporary directory,
+// in memory indexes are unable to apply a second batch
+func openTestWriters(t *testing.T) (beerIndexWriter, breweryIndexWriter *bluge.Writer) {
+	dir := t.TempDir()
+	beerIndexWriter, err := bluge.OpenWriter(bluge.DefaultConfig(filepath.Join(dir, "beers.bluge")).
+		WithVirtualField(bluge.NewKeywordField("_type", typeBeer).StoreValue()))
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = beerIndexWriter.Close()
+	})
+	breweryIndexWriter, err = bluge.OpenWriter(bluge.DefaultConfig(filepath.Join(dir, "breweries.bluge")).
+		WithVirtualField(bluge.NewKeywordField("_type", typeBrewery).StoreValue()))
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = breweryIndexWriter.Close()
+	})
+	return beerIndexWriter, breweryIndexWriter
+}
+
+func writeFile(t *testing.T, dir, filename, data string) {
+	err := ioutil.WriteFile(filepath.Join(dir, filename), []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// indexedSources returns the _source of the documents in the index by ID
+func indexedSources(t *testing.T, indexWriter *bluge.Writer) map[string]string {
+	reader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	dmi, err := reader.Search(context.Background(), bluge.NewAllMatches(bluge.NewMatchAllQuery()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv := make(map[string]string)
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		var id, source string
+		err = next.VisitStoredFields(func(field string, value []byte) bool {
+			switch field {
+			case "_id":
+				id = string(value)
+			case "_source":
+				source = string(value)
+			}
+			return true
+		})
+		rv[id] = source
+		if err == nil {
+			next, err = dmi.Next()
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rv
+}
+
+// loadBreweryStats loads the stats stored with the brewery
+func loadBreweryStats(t *testing.T, breweryIndexWriter *bluge.Writer, breweryID string) *BreweryStats {
+	reader, err := breweryIndexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	source, err := loadSource(reader, breweryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, _, err := unmarshalByType(typeBrewery, breweryID, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := obj.(*Brewery).Stats
+	if stats == nil {
+		t.Fatalf("expected stats for brewery '%s', got none", breweryID)
+	}
+	return stats
+}